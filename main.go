@@ -5,6 +5,7 @@ import (
 	"config"
 	"flag"
 	"game"
+	"game/world"
 	"io"
 	"log"
 	"os"
@@ -24,6 +25,8 @@ func main() {
 
 	debuguser := flag.String("user", "", "Skip login and use this username")
 
+	speed := flag.Float64("speed", 1, "Run the world N times faster than real time for playtesting")
+
 	flag.Parse()
 
 	if *genJson {
@@ -35,11 +38,6 @@ func main() {
 		log.SetFlags(log.LstdFlags | log.Lshortfile)
 	}
 
-	if *debuguser != "" {
-		log.Printf("Using username %v for debug", *debuguser)
-		os.Setenv("RTSUSER", *debuguser)
-	}
-
 	config.Initialize(*configPath)
 
 	// Create log directory
@@ -57,11 +55,49 @@ func main() {
 
 	log.SetOutput(io.MultiWriter(os.Stdout, fileWriter))
 
-	engine, _ := game.NewGameEngine()
+	if err := world.LoadStructures(config.StructureFile); err != nil {
+		log.Fatalf("[ERROR] Unable to load structure data from %s:\n%v", config.StructureFile, err)
+	}
+
+	if *speed <= 0 {
+		log.Fatalln("[ERROR] Speed must be positive")
+	}
+
+	if *speed != 1 {
+		log.Printf("[INFO] World runs at %vx speed", *speed)
+	}
+
+	// Game time is always saved, so it continues after restart instead of
+	// going back to system time, even if speed is changed between runs
+	clock_path := path.Join(config.DBDir, "clock")
+	clock, err := util.LoadScaledClock(clock_path, *speed)
+	if err != nil {
+		log.Fatalln("[ERROR] Unable to load game time:", err)
+	}
+
+	go func() {
+		for range time.Tick(time.Second) {
+			if err := clock.Save(clock_path); err != nil {
+				log.Println("[WARNING]", err)
+			}
+		}
+	}()
+
+	engine, _ := game.NewGameEngine(clock)
 	engine.LoadTerrain(util.Point{-25, -25}, util.Point{24, 24}, "map_river.json")
 	engine.Start()
 
-	server, _ := comm.NewWsServer()
+	auth, err := comm.NewAuthenticator()
+	if err != nil {
+		log.Fatalln("[ERROR] Unable to create authenticator:", err)
+	}
+
+	if *debuguser != "" {
+		log.Printf("Using username %v for debug", *debuguser)
+		auth = comm.DebugAuth{Username: *debuguser}
+	}
+
+	server, _ := comm.NewWsServer(auth)
 	server.Start(9999)
 
 	select {}
@@ -0,0 +1,35 @@
+package comm
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Stub of GitLab user API for staging & tests, maps token to username.
+// Serve it with `http.ListenAndServe` and use its address as GitLab URL
+type GitLabStub map[string]string
+
+func (stub GitLabStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.URL.Path != "/api/v4/user" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(gitlab_user{Message: "404 Not Found"})
+		return
+	}
+
+	token := r.Header.Get("PRIVATE-TOKEN")
+	if auth := r.Header.Get("Authorization"); token == "" && strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	username, ok := stub[token]
+	if !ok || token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(gitlab_user{Message: "401 Unauthorized"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(gitlab_user{Username: username})
+}
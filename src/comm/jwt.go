@@ -0,0 +1,100 @@
+package comm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Registered claims of JWT used by the server
+type JWTClaims struct {
+	Subject   string `json:"sub"`
+	ID        string `json:"jti,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"` // Unix time, never expires if 0
+	NotBefore int64  `json:"nbf,omitempty"` // Unix time
+	IssuedAt  int64  `json:"iat,omitempty"` // Unix time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+func jwtSignature(data string, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Create JWT signed with HS256
+func SignJWT(claims JWTClaims, secret []byte) (string, error) {
+	header, err := json.Marshal(jwtHeader{"HS256", "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	data := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return data + "." + jwtSignature(data, secret), nil
+}
+
+// Verify JWT signed with HS256 and check its valid period at the time
+func ParseJWT(token string, secret []byte, now time.Time) (claims JWTClaims, err error) {
+	if len(secret) == 0 {
+		err = errors.New("JWT secret not set")
+		return
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		err = errors.New("Malformed token")
+		return
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return
+	}
+
+	var header jwtHeader
+	if err = json.Unmarshal(b, &header); err != nil {
+		return
+	}
+
+	// Only accept the algorithm used by server, "none" is never accepted
+	if header.Alg != "HS256" {
+		err = errors.New("Unsupported token algorithm")
+		return
+	}
+
+	if !hmac.Equal([]byte(jwtSignature(parts[0]+"."+parts[1], secret)), []byte(parts[2])) {
+		err = errors.New("Invalid token signature")
+		return
+	}
+
+	if b, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(b, &claims); err != nil {
+		return
+	}
+
+	switch {
+	case claims.ExpiresAt != 0 && now.Unix() >= claims.ExpiresAt:
+		err = errors.New("Token expired")
+	case claims.NotBefore != 0 && now.Unix() < claims.NotBefore:
+		err = errors.New("Token not valid yet")
+	}
+
+	return
+}
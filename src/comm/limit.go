@@ -0,0 +1,113 @@
+package comm
+
+import (
+	"config"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// This file is used to protect websocket server from abusive clients
+
+// Count connections by key, e.g. IP or username
+type connCounter struct {
+	counts map[string]int
+	lock   *sync.Mutex
+}
+
+func newConnCounter() connCounter {
+	return connCounter{make(map[string]int), new(sync.Mutex)}
+}
+
+// Add a connection of the key, returns false if max connections reached.
+// Unlimited if max is 0
+func (counter connCounter) acquire(key string, max int) bool {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+
+	if max > 0 && counter.counts[key] >= max {
+		return false
+	}
+
+	counter.counts[key]++
+	return true
+}
+
+// Remove a connection of the key
+func (counter connCounter) release(key string) {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+
+	if counter.counts[key]--; counter.counts[key] <= 0 {
+		delete(counter.counts, key)
+	}
+}
+
+// Token bucket limiting message rate of a client
+type rateLimiter struct {
+	rate   float64 // Tokens added per second, unlimited if 0
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate, float64(burst), float64(burst), time.Now()}
+}
+
+// Take a token at the time, returns false if no token left
+func (limiter *rateLimiter) allow(now time.Time) bool {
+	if limiter.rate <= 0 {
+		return true
+	}
+
+	limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+	if limiter.tokens > limiter.burst {
+		limiter.tokens = limiter.burst
+	}
+	limiter.last = now
+
+	if limiter.tokens < 1 {
+		return false
+	}
+
+	limiter.tokens--
+	return true
+}
+
+// Check origin of the request with allow-list, any origin is allowed if the
+// list is empty
+func checkOrigin(r *http.Request) bool {
+	if len(config.AllowedOrigins) == 0 {
+		return true
+	}
+
+	origin := r.Header.Get("Origin")
+	for _, allowed := range config.AllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IP of the remote client
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Send close message with reason and close the connection. The client gets
+// the reason from close event
+func closeWithReason(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	conn.Close()
+}
@@ -3,38 +3,75 @@ package comm
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
+	"time"
 
 	"config"
 )
 
+// Authenticator resolves username from the token sent by client on login
+type Authenticator interface {
+	Authenticate(token string, token_type string) (username string, err error)
+}
+
+// Create authenticator selected by config
+func NewAuthenticator() (Authenticator, error) {
+	switch config.AuthProvider {
+	case config.ProviderGitLab:
+		return NewGitLabAuth(config.GitLabURL), nil
+	case config.ProviderTokenFile:
+		return NewStaticTokenAuth(config.AuthTokenFile)
+	case config.ProviderJWT:
+		return JWTAuth{Secret: []byte(config.AuthJWTSecret)}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown auth provider %s", config.AuthProvider)
+}
+
+// Authenticate every client as the same user, used for debugging
+type DebugAuth struct {
+	Username string
+}
+
+func (auth DebugAuth) Authenticate(token string, token_type string) (string, error) {
+	return auth.Username, nil
+}
+
 type gitlab_user struct {
 	Id       int
 	Username string
 	Message  string
 }
 
-// Login with GitLab token
-func Login(token string, token_type string) (string, error) {
-	if username := os.Getenv("RTSUSER"); username != "" {
-		return username, nil
-	}
+// Login with GitLab private token or OAuth access token
+type GitLabAuth struct {
+	BaseURL string // GitLab root, API is under BaseURL + "/api/v4"
+	Client  *http.Client
+}
 
-	client := &http.Client{}
+func NewGitLabAuth(base_url string) GitLabAuth {
+	return GitLabAuth{base_url, &http.Client{Timeout: 10 * time.Second}}
+}
 
-	req, err := http.NewRequest("GET", config.Hostname+"/gitlab/api/v4/user", nil)
+func (auth GitLabAuth) Authenticate(token string, token_type string) (string, error) {
+	req, err := http.NewRequest("GET", auth.BaseURL+"/api/v4/user", nil)
 	if err != nil {
 		return "", err
 	}
 
 	// Set access token type
-	q := req.URL.Query()
-	q.Add(token_type, token)
-	req.URL.RawQuery = q.Encode()
+	switch token_type {
+	case "private_token":
+		req.Header.Set("PRIVATE-TOKEN", token)
+	case "access_token":
+		req.Header.Set("Authorization", "Bearer "+token)
+	default:
+		return "", fmt.Errorf("Unsupported token type %s", token_type)
+	}
 
-	rsp, err := client.Do(req)
+	rsp, err := auth.Client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -53,5 +90,55 @@ func Login(token string, token_type string) (string, error) {
 		return "", errors.New(user.Message)
 	}
 
+	if rsp.StatusCode != http.StatusOK || user.Username == "" {
+		return "", fmt.Errorf("GitLab responded %s", rsp.Status)
+	}
+
 	return user.Username, nil
 }
+
+// Login with tokens listed in a JSON file, which maps token to username
+type StaticTokenAuth struct {
+	tokens map[string]string
+}
+
+func NewStaticTokenAuth(filename string) (auth StaticTokenAuth, err error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(b, &auth.tokens)
+	return
+}
+
+func (auth StaticTokenAuth) Authenticate(token string, token_type string) (string, error) {
+	if username, ok := auth.tokens[token]; ok && token != "" {
+		return username, nil
+	}
+
+	return "", errors.New("Invalid token")
+}
+
+// Login with JWT signed by HS256, username is given by subject claim
+type JWTAuth struct {
+	Secret []byte
+}
+
+func (auth JWTAuth) Authenticate(token string, token_type string) (string, error) {
+	claims, err := ParseJWT(token, auth.Secret, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	if claims.Subject == "" {
+		return "", errors.New("Token has no subject")
+	}
+
+	// Session tokens are only used for resuming sessions
+	if claims.Audience == sessionAudience {
+		return "", errors.New("Session token not accepted for login")
+	}
+
+	return claims.Subject, nil
+}
@@ -0,0 +1,79 @@
+package comm
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGitLabAuth(t *testing.T) {
+	stub := httptest.NewServer(GitLabStub{"private": "alice", "oauth": "bob"})
+	defer stub.Close()
+
+	auth := NewGitLabAuth(stub.URL)
+
+	cases := []struct {
+		token, token_type, username string
+		ok                          bool
+	}{
+		{"private", "private_token", "alice", true},
+		{"oauth", "access_token", "bob", true},
+		{"unknown", "private_token", "", false},
+		{"unknown", "access_token", "", false},
+		{"private", "password", "", false},
+	}
+
+	for _, c := range cases {
+		username, err := auth.Authenticate(c.token, c.token_type)
+		if c.ok && (err != nil || username != c.username) {
+			t.Errorf("%s %s: got %q, %v, want %q", c.token_type, c.token, username, err, c.username)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s %s: got %q, want error", c.token_type, c.token, username)
+		}
+	}
+}
+
+func TestParseJWT(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1000000, 0)
+
+	sign := func(claims JWTClaims) string {
+		token, err := SignJWT(claims, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	valid := sign(JWTClaims{Subject: "alice", ExpiresAt: now.Unix() + 60})
+
+	// Unsigned token with the payload of the valid token
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	none := header + "." + strings.Split(valid, ".")[1] + "."
+
+	cases := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", valid, true},
+		{"bad signature", valid[:len(valid)-2] + "AA", false},
+		{"alg none", none, false},
+		{"expired", sign(JWTClaims{Subject: "alice", ExpiresAt: now.Unix()}), false},
+		{"not valid yet", sign(JWTClaims{Subject: "alice", NotBefore: now.Unix() + 1}), false},
+		{"malformed", "abc.def", false},
+	}
+
+	for _, c := range cases {
+		claims, err := ParseJWT(c.token, secret, now)
+		if c.ok && (err != nil || claims.Subject != "alice") {
+			t.Errorf("%s: got %v, %v", c.name, claims, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s: accepted", c.name)
+		}
+	}
+}
@@ -28,6 +28,14 @@ const (
 	HomePointResponse
 	OccupyRequest
 	Message
+	ErrorResponse
+	AckResponse
+	BattleReport
+	StructureCatalogRequest
+	StructureCatalogResponse
+	BulkActionRequest
+	BulkActionResponse
+	SessionRefresh
 )
 
 var msg_type = []string{
@@ -44,6 +52,14 @@ var msg_type = []string{
 	"HomePointResponse",
 	"OccupyRequest",
 	"Message",
+	"ErrorResponse",
+	"AckResponse",
+	"BattleReport",
+	"StructureCatalogRequest",
+	"StructureCatalogResponse",
+	"BulkActionRequest",
+	"BulkActionResponse",
+	"SessionRefresh",
 }
 
 func (mtype MsgType) String() string {
@@ -97,12 +113,36 @@ type MessageWrapper struct {
 // Data container for server/client communication
 type Payload struct {
 	Msg_type MsgType
+
+	// Optional ID supplied by client, echoed in the response of the request
+	Request_id string `json:",omitempty"`
+}
+
+// Signed token to resume the session after reconnecting, sent as token with
+// token type "session" on login. Refreshed before expired while connected
+type SessionToken struct {
+	Session_token   string `json:",omitempty"`
+	Session_expires int64  `json:",omitempty"` // Unix time
 }
 
 type UsernamePayload struct {
 	Payload
 
 	Username string
+	SessionToken
+}
+
+type SessionPayload struct {
+	Payload
+	SessionToken
+}
+
+// Client logged in, sent from websocket server to game
+type LoginPayload struct {
+	Payload
+
+	Session string // Session ID, kept when the session is resumed
+	Resumed bool
 }
 
 type LogoutPayload struct {
@@ -117,3 +157,35 @@ type MessagePayload struct {
 	Avatar  string
 	Message string
 }
+
+// Machine-readable reason of a rejected request
+type ErrorCode string
+
+const (
+	InvalidRequest      ErrorCode = "InvalidRequest"      // Unknown message type or malformed payload
+	PlayerNotFound      ErrorCode = "PlayerNotFound"      // Player has not chosen home point yet
+	PermissionDenied    ErrorCode = "PermissionDenied"    // Player do not own the chunk
+	NotEnoughMoney      ErrorCode = "NotEnoughMoney"      // Player do not have enough money
+	NotEnoughPopulation ErrorCode = "NotEnoughPopulation" // Chunk do not have enough population
+	NotEnoughTroops     ErrorCode = "NotEnoughTroops"     // Chunk do not have enough troops
+	StructureNotFound   ErrorCode = "StructureNotFound"   // Structure not exist on the chunk
+	UnknownStructure    ErrorCode = "UnknownStructure"    // Structure ID not defined
+	InvalidOperation    ErrorCode = "InvalidOperation"    // Rejected by world rules
+	ChunkOccupied       ErrorCode = "ChunkOccupied"       // Chunk already owned by other player
+	ServerError         ErrorCode = "ServerError"         // Database or internal error
+)
+
+// Acknowledgement of an accepted request, ErrorResponse is used when rejected
+type AckPayload struct {
+	Payload
+
+	Request MsgType // Message type of the accepted request
+}
+
+type ErrorPayload struct {
+	Payload
+
+	Request MsgType // Message type of the rejected request
+	Code    ErrorCode
+	Message string
+}
@@ -0,0 +1,137 @@
+package comm
+
+import (
+	"config"
+	"encoding/json"
+	"sync"
+)
+
+// This file is used to buffer messages sent to each client, so that a slow
+// client doesn't block sending to other clients
+
+// Stats of send queue of a client
+type QueueStats struct {
+	Username  string
+	Cid       int
+	Depth     int    // Messages waiting to be sent
+	Peak      int    // Max depth ever reached
+	Dropped   uint64 // Messages dropped on overflow
+	Coalesced uint64 // Messages replaced by newer updates on overflow
+}
+
+// Bounded queue of messages waiting to be sent to a client
+type sendQueue struct {
+	msgs   [][]byte
+	size   int
+	policy string
+	ready  chan struct{} // notified when messages pushed
+	lock   *sync.Mutex
+
+	overflowed bool // client should be disconnected by its writer goroutine
+
+	peak      int
+	dropped   uint64
+	coalesced uint64
+}
+
+func newSendQueue(size int, policy string) *sendQueue {
+	return &sendQueue{size: size, policy: policy, ready: make(chan struct{}, 1), lock: new(sync.Mutex)}
+}
+
+// Add message to the queue, overflow is handled with the policy of the
+// queue. Returns false if the queue just overflowed with disconnect policy,
+// the writer goroutine is woken up to disconnect the client
+func (queue *sendQueue) push(data []byte) bool {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	// Client is being disconnected
+	if queue.overflowed {
+		return true
+	}
+
+	if len(queue.msgs) >= queue.size {
+		switch queue.policy {
+		case config.OverflowDisconnect:
+			queue.overflowed = true
+			queue.notify()
+			return false
+		case config.OverflowCoalesce:
+			if i := queue.outdated(data); i >= 0 {
+				queue.msgs = append(queue.msgs[:i], queue.msgs[i+1:]...)
+				queue.coalesced++
+				break
+			}
+			fallthrough
+		default:
+			queue.msgs = queue.msgs[1:]
+			queue.dropped++
+		}
+	}
+
+	queue.msgs = append(queue.msgs, data)
+	if len(queue.msgs) > queue.peak {
+		queue.peak = len(queue.msgs)
+	}
+
+	queue.notify()
+	return true
+}
+
+// Wake up writer goroutine, caller should hold the lock
+func (queue *sendQueue) notify() {
+	select {
+	case queue.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Take all messages in the queue, overflowed is true if the client should be
+// disconnected instead
+func (queue *sendQueue) pop() (msgs [][]byte, overflowed bool) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	msgs, queue.msgs = queue.msgs, nil
+	return msgs, queue.overflowed
+}
+
+// Index of the oldest queued message replaced by the message, -1 if none.
+// Map, minimap & player data pushed by server contain whole state, so only
+// the latest one is needed. Responses of requests are never replaced
+func (queue *sendQueue) outdated(data []byte) int {
+	msg_type, ok := updateType(data)
+	if !ok {
+		return -1
+	}
+
+	for i, queued := range queue.msgs {
+		if t, ok := updateType(queued); ok && t == msg_type {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Message type of the data if it's an update pushed by server
+func updateType(data []byte) (MsgType, bool) {
+	var payload Payload
+	if err := json.Unmarshal(data, &payload); err != nil || payload.Request_id != "" {
+		return 0, false
+	}
+
+	switch payload.Msg_type {
+	case MapDataResponse, MinimapDataResponse, PlayerDataResponse:
+		return payload.Msg_type, true
+	}
+
+	return 0, false
+}
+
+func (queue *sendQueue) stats() QueueStats {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	return QueueStats{Depth: len(queue.msgs), Peak: queue.peak, Dropped: queue.dropped, Coalesced: queue.coalesced}
+}
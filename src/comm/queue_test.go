@@ -0,0 +1,63 @@
+package comm
+
+import (
+	"config"
+	"fmt"
+	"testing"
+)
+
+func update(msg_type MsgType, request_id string, n int) []byte {
+	return []byte(fmt.Sprintf(`{"Msg_type":%d,"Request_id":%q,"N":%d}`, msg_type, request_id, n))
+}
+
+func TestSendQueueCoalescesUpdates(t *testing.T) {
+	queue := newSendQueue(3, config.OverflowCoalesce)
+	queue.push(update(MapDataResponse, "", 1))
+	queue.push(update(Message, "", 2))
+	queue.push(update(MapDataResponse, "r1", 3))
+
+	// Replaces the pushed map update, never the response of request
+	queue.push(update(MapDataResponse, "", 4))
+	if stats := queue.stats(); stats.Depth != 3 || stats.Coalesced != 1 || stats.Dropped != 0 {
+		t.Fatalf("stats %+v after coalesced", stats)
+	}
+
+	// Nothing to replace, the oldest is dropped
+	queue.push(update(Message, "", 5))
+	if stats := queue.stats(); stats.Depth != 3 || stats.Dropped != 1 || stats.Peak != 3 {
+		t.Fatalf("stats %+v after dropped", stats)
+	}
+
+	msgs, overflowed := queue.pop()
+	want := []string{string(update(MapDataResponse, "r1", 3)), string(update(MapDataResponse, "", 4)), string(update(Message, "", 5))}
+	if overflowed || len(msgs) != len(want) {
+		t.Fatalf("popped %d messages, overflowed %v", len(msgs), overflowed)
+	}
+	for i := range want {
+		if string(msgs[i]) != want[i] {
+			t.Errorf("message %d: %s, want %s", i, msgs[i], want[i])
+		}
+	}
+}
+
+func TestSendQueueDisconnectsOnOverflow(t *testing.T) {
+	queue := newSendQueue(1, config.OverflowDisconnect)
+
+	if !queue.push(update(Message, "", 1)) {
+		t.Fatal("rejected before full")
+	}
+	if queue.push(update(Message, "", 2)) {
+		t.Fatal("accepted after full")
+	}
+
+	// Writer goroutine is woken up to disconnect the client
+	select {
+	case <-queue.ready:
+	default:
+		t.Fatal("writer not notified")
+	}
+
+	if _, overflowed := queue.pop(); !overflowed {
+		t.Fatal("overflow not reported to writer")
+	}
+}
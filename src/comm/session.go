@@ -0,0 +1,66 @@
+package comm
+
+import (
+	"config"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+)
+
+// Token type of session resume token sent by client on login
+const SessionTokenType = "session"
+
+// Audience claim of session tokens, so they are never accepted as login JWT
+// or the other way round even if both use the same secret
+const sessionAudience = "session"
+
+// Issue & verify signed session tokens, so reconnecting clients skip login
+type sessionSigner struct {
+	secret []byte
+	ttl    int64 // Seconds
+}
+
+func newSessionSigner() sessionSigner {
+	secret := []byte(config.SessionSecret)
+
+	// Tokens issued before restart become invalid with random secret
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalln("[ERROR] Unable to generate session secret:", err)
+		}
+	}
+
+	return sessionSigner{secret, config.SessionTTL}
+}
+
+// Random ID of new session
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (signer sessionSigner) issue(username string, session string) (token SessionToken, err error) {
+	now := time.Now().Unix()
+
+	token.Session_expires = now + signer.ttl
+	token.Session_token, err = SignJWT(JWTClaims{Subject: username, ID: session, Audience: sessionAudience, IssuedAt: now, ExpiresAt: token.Session_expires}, signer.secret)
+	return
+}
+
+func (signer sessionSigner) verify(token string) (username string, session string, err error) {
+	claims, err := ParseJWT(token, signer.secret, time.Now())
+	if err != nil {
+		return
+	}
+
+	if claims.Audience != sessionAudience || claims.Subject == "" || claims.ID == "" {
+		err = errors.New("Invalid session token")
+		return
+	}
+
+	return claims.Subject, claims.ID, nil
+}
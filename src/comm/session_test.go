@@ -0,0 +1,34 @@
+package comm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionTokenNotSwappedWithLoginJWT(t *testing.T) {
+	secret := []byte("shared")
+	signer := sessionSigner{secret, 300}
+	auth := JWTAuth{Secret: secret}
+
+	token, err := signer.issue("alice", "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if username, session, err := signer.verify(token.Session_token); err != nil || username != "alice" || session != "s1" {
+		t.Fatalf("verify session token: %q, %q, %v", username, session, err)
+	}
+
+	if _, err := auth.Authenticate(token.Session_token, "jwt"); err == nil {
+		t.Error("session token accepted for login")
+	}
+
+	login, err := SignJWT(JWTClaims{Subject: "alice", ID: "s1", ExpiresAt: time.Now().Unix() + 60}, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := signer.verify(login); err == nil {
+		t.Error("login JWT accepted as session token")
+	}
+}
@@ -1,12 +1,15 @@
 package comm
 
 import (
+	"config"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"log"
+	"net"
 	"net/http"
 	"sync"
+	"time"
 )
 
 // Struct to store connected client
@@ -15,7 +18,28 @@ type WsClient struct {
 
 	cid      int
 	username string
-	token    string
+	session  string // Session ID, kept when the session is resumed
+	resumed  bool
+	ip       string
+	queue    *sendQueue // messages waiting to be sent by writer goroutine
+}
+
+// Extend read deadline of the client, called when any message or pong received
+func (client WsClient) alive() {
+	client.SetReadDeadline(time.Now().Add(time.Duration(config.PongTimeout) * time.Second))
+}
+
+// Write message to client within write timeout. Connection is closed on
+// failure, so the reading goroutine stops and the client logs out
+func (client WsClient) send(data []byte) error {
+	client.SetWriteDeadline(time.Now().Add(time.Duration(config.WriteTimeout) * time.Second))
+
+	err := client.WriteMessage(websocket.TextMessage, data)
+	if err != nil {
+		client.Close()
+	}
+
+	return err
 }
 
 // Struct for websocket server
@@ -25,9 +49,13 @@ type WsServer struct {
 	logout_queue chan WsClient         // ws clients who are going to logout
 	mbus         *MBusNode
 	clientsLock  *sync.RWMutex
+	auth         Authenticator
+	sessions     sessionSigner
+	ip_conns     connCounter // connections of each IP
+	user_conns   connCounter // connections of each user
 }
 
-func NewWsServer() (server *WsServer, err error) {
+func NewWsServer(auth Authenticator) (server *WsServer, err error) {
 	// Initialize WsServer component
 	clients := make(map[string][]WsClient)
 	login_queue := make(chan WsClient)
@@ -38,7 +66,7 @@ func NewWsServer() (server *WsServer, err error) {
 		return
 	}
 
-	server = &WsServer{clients, login_queue, logout_queue, mbus, new(sync.RWMutex)}
+	server = &WsServer{clients, login_queue, logout_queue, mbus, new(sync.RWMutex), auth, newSessionSigner(), newConnCounter(), newConnCounter()}
 	return
 }
 
@@ -57,36 +85,71 @@ func (server WsServer) Start(port int) {
 
 	// http handler
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+		ip := remoteIP(r)
+		if !server.ip_conns.acquire(ip, config.MaxConnsPerIP) {
+			log.Println("[WARNING] Too many connections from", ip)
+			http.Error(w, "Too many connections", http.StatusTooManyRequests)
+			return
+		}
+
+		upgrader := websocket.Upgrader{CheckOrigin: checkOrigin}
 
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			log.Println("[ERROR]", err)
+			server.ip_conns.release(ip)
 			return
 		}
 
 		// Read JSON string send from client, use token to login
-		// TODO: Support GitLab Private token and Access token
 		var login_data struct {
 			Token_type string
 			Token      string
 		}
 
+		conn.SetReadDeadline(time.Now().Add(time.Duration(config.LoginTimeout) * time.Second))
+
 		if err := conn.ReadJSON(&login_data); err != nil {
 			log.Println("[ERROR]", err)
-			conn.Close()
+			server.ip_conns.release(ip)
+
+			if err, ok := err.(net.Error); ok && err.Timeout() {
+				closeWithReason(conn, websocket.ClosePolicyViolation, "Login timeout")
+			} else {
+				closeWithReason(conn, websocket.CloseUnsupportedData, "Invalid login data")
+			}
 			return
 		}
 
+		conn.SetReadDeadline(time.Time{})
+
+		var username, session string
+		resumed := login_data.Token_type == SessionTokenType
+
+		if resumed {
+			// Resume previous session without authenticating again
+			username, session, err = server.sessions.verify(login_data.Token)
+		} else {
+			username, err = server.auth.Authenticate(login_data.Token, login_data.Token_type)
+			session = newSessionID()
+		}
+
 		// Close connection when login failed
-		username, err := Login(login_data.Token, login_data.Token_type)
 		if err != nil {
 			log.Println("[ERROR]", err)
-			conn.Close()
+			server.ip_conns.release(ip)
+			closeWithReason(conn, websocket.ClosePolicyViolation, "Login failed")
+			return
+		}
+
+		if !server.user_conns.acquire(username, config.MaxConnsPerUser) {
+			log.Println("[WARNING] Too many connections of user", username)
+			server.ip_conns.release(ip)
+			closeWithReason(conn, websocket.CloseTryAgainLater, "Too many connections")
 			return
 		}
 
-		server.login_queue <- WsClient{conn, cid_generator(), username, login_data.Token}
+		server.login_queue <- WsClient{conn, cid_generator(), username, session, resumed, ip, newSendQueue(config.SendBuffer, config.OverflowPolicy)}
 	})
 
 	// listening
@@ -108,12 +171,29 @@ func (server WsServer) Start(port int) {
 
 	// Handle message from MBus ( WsClient write )
 	go server.write2client()
+
+	if config.StatsInterval > 0 {
+		go server.logQueueStats(time.Duration(config.StatsInterval) * time.Second)
+	}
 }
 
 func (server WsServer) loginHandler(client WsClient) {
 	cid := client.cid
 	username := client.username
 
+	token, err := server.sessions.issue(username, client.session)
+	if err != nil {
+		log.Println("[WARNING]", err)
+	}
+
+	// Send username & session token to browser, queued before any other
+	// message to the client
+	if b, err := json.Marshal(UsernamePayload{Payload{Msg_type: LoginResponse}, username, token}); err != nil {
+		log.Println("[WARNING]", err)
+	} else {
+		client.queue.push(b)
+	}
+
 	// Add new client to client list
 	server.clientsLock.Lock()
 	if user_clients, ok := server.clients[username]; !ok {
@@ -125,27 +205,115 @@ func (server WsServer) loginHandler(client WsClient) {
 
 	log.Printf("[INFO] New client of user %s connected (cid: %v)", username, cid)
 
+	done := make(chan struct{})
+	limiter := newRateLimiter(config.RateLimit, config.RateBurst)
+
+	// Half-open connection is found by missing pong
+	client.alive()
+	client.SetPongHandler(func(string) error {
+		client.alive()
+		return nil
+	})
+
 	// Start goroutine to handle massage from each websocket client ( WsClient read )
 	go func() {
 		for {
 			_, msg, err := client.ReadMessage()
 			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				if err, ok := err.(net.Error); ok && err.Timeout() {
+					log.Printf("[INFO] %s's client, cid %d: heartbeat timeout", username, cid)
+				} else if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
 					log.Printf("%s's client, cid %d: %s", username, cid, err.Error())
 				}
 
+				close(done)
 				server.logout_queue <- client
 				return
 			}
 
+			// Disconnect client sending too many messages, the next read fails
+			// and the client logs out
+			if !limiter.allow(time.Now()) {
+				log.Printf("[WARNING] %s's client, cid %d: rate limit exceeded", username, cid)
+				closeWithReason(client.Conn, websocket.ClosePolicyViolation, "Rate limit exceeded")
+				continue
+			}
+
+			client.alive()
 			server.mbus.Write("game", MessageWrapper{Cid: cid, Username: username, Data: msg})
 		}
 	}()
 
-	// Send username to browser
-	client.WriteJSON(UsernamePayload{Payload{LoginResponse}, username})
+	// Send queued messages, a slow client only blocks its own goroutine
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-client.queue.ready:
+				msgs, overflowed := client.queue.pop()
+				if overflowed {
+					closeWithReason(client.Conn, websocket.CloseTryAgainLater, "Send buffer overflow")
+					return
+				}
+
+				for _, msg := range msgs {
+					if err := client.send(msg); err != nil {
+						if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+							log.Println("[WARNING]", err)
+						}
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	// Ping client periodically, close the connection if ping can't be sent
+	go func() {
+		ticker := time.NewTicker(time.Duration(config.PingInterval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				deadline := time.Now().Add(time.Duration(config.WriteTimeout) * time.Second)
+				if err := client.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+					log.Printf("[INFO] %s's client, cid %d: %s", username, cid, err.Error())
+					client.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	// Refresh session token before expired
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Duration(server.sessions.ttl) * time.Second / 2):
+				token, err := server.sessions.issue(username, client.session)
+				if err != nil {
+					log.Println("[WARNING]", err)
+					continue
+				}
+
+				b, err := json.Marshal(SessionPayload{Payload{Msg_type: SessionRefresh}, token})
+				if err != nil {
+					log.Println("[WARNING]", err)
+					continue
+				}
+
+				server.mbus.Write("ws", MessageWrapper{Cid: cid, Username: username, SendTo: SendToClient, Data: b})
+			}
+		}
+	}()
 
-	b, err := json.Marshal(Payload{LoginRequest})
+	b, err := json.Marshal(LoginPayload{Payload{Msg_type: LoginRequest}, client.session, client.resumed})
 	if err != nil {
 		log.Println("[WARNING]", err)
 		return
@@ -158,6 +326,12 @@ func (server WsServer) logoutHandler(client WsClient) {
 	cid := client.cid
 	username := client.username
 
+	server.ip_conns.release(client.ip)
+	server.user_conns.release(username)
+
+	// Connection may be still open if the client is timed out
+	client.Close()
+
 	find := func(clients []WsClient, cid int) int {
 		for i, client := range clients {
 			if client.cid == cid {
@@ -174,7 +348,8 @@ func (server WsServer) logoutHandler(client WsClient) {
 			server.clients[username] = append(user_clients[:i], user_clients[i+1:]...)
 		}
 
-		b, err := json.Marshal(LogoutPayload{Payload{LogoutRequest}, len(user_clients) == 0})
+		// Remove user from game when its last client logged out
+		b, err := json.Marshal(LogoutPayload{Payload{Msg_type: LogoutRequest}, len(server.clients[username]) == 0})
 		if err != nil {
 			log.Println("[WARNING]", err)
 			server.clientsLock.Unlock()
@@ -202,49 +377,82 @@ func (server WsServer) write2client() {
 			return -1
 		}
 
-		// check the username and cid for security
-		server.clientsLock.RLock()
-		if send_to != Broadcast {
-			if user_clients, ok := server.clients[username]; send_to == SendToUser && !ok {
-				server.clientsLock.RUnlock()
-				continue
-			} else if send_to == SendToClient && (!ok || find(user_clients, cid) == -1) {
-				server.clientsLock.RUnlock()
-				continue
-			}
-		}
-		server.clientsLock.RUnlock()
-
+		// Messages are only queued here, so the lock is never held on writing
 		server.clientsLock.RLock()
 		switch send_to {
 		case Broadcast:
 			for _, user_clients := range server.clients {
 				for _, client := range user_clients {
-					err := client.WriteMessage(websocket.TextMessage, msg_wrapper.Data)
-					if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-						log.Println("[WARNING]", err)
-					}
+					server.enqueue(client, msg_wrapper.Data)
 				}
 			}
 		case SendToUser:
 			if user_clients, ok := server.clients[username]; ok {
 				for _, client := range user_clients {
-					err := client.WriteMessage(websocket.TextMessage, msg_wrapper.Data)
-					if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-						log.Println("[WARNING]", err)
-					}
+					server.enqueue(client, msg_wrapper.Data)
 				}
 			}
 		case SendToClient:
+			// check the username and cid for security
 			if user_clients, ok := server.clients[username]; ok {
 				if i := find(user_clients, cid); i != -1 {
-					err := user_clients[i].WriteMessage(websocket.TextMessage, msg_wrapper.Data)
-					if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
-						log.Println("[WARNING]", err)
-					}
+					server.enqueue(user_clients[i], msg_wrapper.Data)
 				}
 			}
 		}
 		server.clientsLock.RUnlock()
 	}
 }
+
+// Queue message to the client, never writes to network so a slow client
+// doesn't block others. Client overflowing its send buffer with disconnect
+// policy is disconnected by its writer goroutine
+func (server WsServer) enqueue(client WsClient, data []byte) {
+	if !client.queue.push(data) {
+		log.Printf("[WARNING] %s's client, cid %d: send buffer overflow", client.username, client.cid)
+	}
+}
+
+// Stats of send queues of all clients
+func (server WsServer) QueueStats() (stats []QueueStats) {
+	server.clientsLock.RLock()
+	defer server.clientsLock.RUnlock()
+
+	for username, user_clients := range server.clients {
+		for _, client := range user_clients {
+			stat := client.queue.stats()
+			stat.Username, stat.Cid = username, client.cid
+			stats = append(stats, stat)
+		}
+	}
+
+	return
+}
+
+// Log depth of send queues periodically
+func (server WsServer) logQueueStats(interval time.Duration) {
+	for range time.Tick(interval) {
+		var depth, max_depth, peak int
+		var dropped, coalesced uint64
+		var max_client QueueStats
+
+		stats := server.QueueStats()
+		for _, stat := range stats {
+			depth += stat.Depth
+			dropped += stat.Dropped
+			coalesced += stat.Coalesced
+
+			if stat.Peak > peak {
+				peak = stat.Peak
+			}
+
+			if stat.Depth > max_depth {
+				max_depth = stat.Depth
+				max_client = stat
+			}
+		}
+
+		log.Printf("[INFO] Send queues: %d clients, %d queued, max %d (%s, cid %d), peak %d, %d dropped, %d coalesced",
+			len(stats), depth, max_depth, max_client.Username, max_client.Cid, peak, dropped, coalesced)
+	}
+}
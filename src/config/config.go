@@ -11,12 +11,78 @@ const (
 	idHostname = "hostname"
 	idDBDir    = "db_dir"
 	idLogDir   = "log_dir"
+
+	idStructureFile = "structure_file"
+	idBuildSlots    = "build_slots"
+
+	idAuthProvider  = "auth_provider"
+	idGitLabURL     = "gitlab_url"
+	idAuthTokenFile = "auth_token_file"
+	idAuthJWTSecret = "auth_jwt_secret"
+
+	idSessionSecret = "session_secret"
+	idSessionTTL    = "session_ttl"
+
+	idLoginTimeout    = "login_timeout"
+	idMaxConnsPerIP   = "max_conns_per_ip"
+	idMaxConnsPerUser = "max_conns_per_user"
+	idAllowedOrigins  = "allowed_origins"
+	idRateLimit       = "rate_limit"
+	idRateBurst       = "rate_burst"
+
+	idPingInterval = "ping_interval"
+	idPongTimeout  = "pong_timeout"
+	idWriteTimeout = "write_timeout"
+
+	idSendBuffer     = "send_buffer"
+	idOverflowPolicy = "overflow_policy"
+	idStatsInterval  = "stats_interval"
+)
+
+// Authentication providers
+const (
+	ProviderGitLab    = "gitlab"     // GitLab private token or OAuth access token
+	ProviderTokenFile = "token_file" // Static tokens listed in a file
+	ProviderJWT       = "jwt"        // JWT signed with shared secret
+)
+
+// Policies on sending to client whose send buffer is full
+const (
+	OverflowDropOldest = "drop_oldest" // Drop the oldest message in buffer
+	OverflowCoalesce   = "coalesce"    // Replace outdated map & player updates, drop the oldest if none
+	OverflowDisconnect = "disconnect"  // Disconnect the client
 )
 
 var (
 	Hostname string
 	DBDir    string
 	LogDir   string
+
+	StructureFile string = "src/game/world/structures.json"
+	BuildSlots    int    = 2 // Structures built at the same time on a chunk
+
+	AuthProvider  string = ProviderGitLab
+	GitLabURL     string // Hostname + "/gitlab" if not set
+	AuthTokenFile string
+	AuthJWTSecret string
+
+	SessionSecret string       // Random secret generated on startup if not set
+	SessionTTL    int64  = 300 // Seconds for a disconnected client to resume its session
+
+	LoginTimeout    int64    = 10 // Seconds for client to send login data after connected
+	MaxConnsPerIP   int      = 32 // Connections from the same IP, unlimited if 0
+	MaxConnsPerUser int      = 8  // Connections of the same user, unlimited if 0
+	AllowedOrigins  []string      // Origins allowed to connect, any origin if empty
+	RateLimit       float64  = 20 // Messages per second from a client, unlimited if 0
+	RateBurst       int      = 40 // Messages a client can send at once
+
+	PingInterval int64 = 30 // Seconds between pings sent to client
+	PongTimeout  int64 = 60 // Seconds to wait for any message from client before disconnecting
+	WriteTimeout int64 = 10 // Seconds to wait for writing a message to client
+
+	SendBuffer     int    = 256 // Messages buffered for each client
+	OverflowPolicy string = OverflowCoalesce
+	StatsInterval  int64  = 60 // Seconds between logging send queue stats, disabled if 0
 )
 
 // Initialize : Load default config and override with data
@@ -36,11 +102,35 @@ func Initialize(path string) {
 
 	apply(configData)
 
-	log.Printf("[INFO] Using config from %v:"+strings.Repeat("\n\t%v : %v", 3)+"\n",
+	if GitLabURL == "" {
+		GitLabURL = Hostname + "/gitlab"
+	}
+
+	log.Printf("[INFO] Using config from %v:"+strings.Repeat("\n\t%v : %v", 23)+"\n",
 		path,
 		idHostname, Hostname,
 		idDBDir, DBDir,
-		idLogDir, LogDir)
+		idLogDir, LogDir,
+		idStructureFile, StructureFile,
+		idBuildSlots, BuildSlots,
+		idAuthProvider, AuthProvider,
+		idGitLabURL, GitLabURL,
+		idAuthTokenFile, AuthTokenFile,
+		idAuthJWTSecret, strings.Repeat("*", len(AuthJWTSecret)),
+		idSessionSecret, strings.Repeat("*", len(SessionSecret)),
+		idSessionTTL, SessionTTL,
+		idLoginTimeout, LoginTimeout,
+		idMaxConnsPerIP, MaxConnsPerIP,
+		idMaxConnsPerUser, MaxConnsPerUser,
+		idAllowedOrigins, AllowedOrigins,
+		idRateLimit, RateLimit,
+		idRateBurst, RateBurst,
+		idPingInterval, PingInterval,
+		idPongTimeout, PongTimeout,
+		idWriteTimeout, WriteTimeout,
+		idSendBuffer, SendBuffer,
+		idOverflowPolicy, OverflowPolicy,
+		idStatsInterval, StatsInterval)
 
 	// Verify config
 	if msglist := verify(); len(msglist) > 0 {
@@ -64,6 +154,60 @@ func apply(data map[string]interface{}) {
 				DBDir = s
 			case idLogDir:
 				LogDir = s
+			case idStructureFile:
+				StructureFile = s
+			case idAuthProvider:
+				AuthProvider = s
+			case idGitLabURL:
+				GitLabURL = s
+			case idAuthTokenFile:
+				AuthTokenFile = s
+			case idAuthJWTSecret:
+				AuthJWTSecret = s
+			case idSessionSecret:
+				SessionSecret = s
+			case idOverflowPolicy:
+				OverflowPolicy = s
+			}
+		case float64:
+			n := v.(float64)
+			switch k {
+			case idBuildSlots:
+				BuildSlots = int(n)
+			case idSessionTTL:
+				SessionTTL = int64(n)
+			case idLoginTimeout:
+				LoginTimeout = int64(n)
+			case idMaxConnsPerIP:
+				MaxConnsPerIP = int(n)
+			case idMaxConnsPerUser:
+				MaxConnsPerUser = int(n)
+			case idRateLimit:
+				RateLimit = n
+			case idRateBurst:
+				RateBurst = int(n)
+			case idPingInterval:
+				PingInterval = int64(n)
+			case idPongTimeout:
+				PongTimeout = int64(n)
+			case idWriteTimeout:
+				WriteTimeout = int64(n)
+			case idSendBuffer:
+				SendBuffer = int(n)
+			case idStatsInterval:
+				StatsInterval = int64(n)
+			}
+		case []interface{}:
+			var list []string
+			for _, item := range v.([]interface{}) {
+				if s, ok := item.(string); ok {
+					list = append(list, s)
+				}
+			}
+
+			switch k {
+			case idAllowedOrigins:
+				AllowedOrigins = list
 			}
 		}
 	}
@@ -88,5 +232,65 @@ func verify() (msglist []string) {
 		msglist = append(msglist, "\""+idLogDir+"\""+cannotBeBlank)
 	}
 
+	if StructureFile == "" {
+		msglist = append(msglist, "\""+idStructureFile+"\""+cannotBeBlank)
+	}
+
+	switch AuthProvider {
+	case ProviderGitLab:
+	case ProviderTokenFile:
+		if AuthTokenFile == "" {
+			msglist = append(msglist, "\""+idAuthTokenFile+"\""+cannotBeBlank)
+		}
+	case ProviderJWT:
+		if AuthJWTSecret == "" {
+			msglist = append(msglist, "\""+idAuthJWTSecret+"\""+cannotBeBlank)
+		}
+	default:
+		msglist = append(msglist, "\""+idAuthProvider+"\" must be one of "+strings.Join([]string{ProviderGitLab, ProviderTokenFile, ProviderJWT}, ", ")+".")
+	}
+
+	if SessionTTL <= 0 {
+		msglist = append(msglist, "\""+idSessionTTL+"\" must be positive.")
+	}
+
+	if LoginTimeout <= 0 {
+		msglist = append(msglist, "\""+idLoginTimeout+"\" must be positive.")
+	}
+
+	if MaxConnsPerIP < 0 || MaxConnsPerUser < 0 || RateLimit < 0 {
+		msglist = append(msglist, "\""+idMaxConnsPerIP+"\", \""+idMaxConnsPerUser+"\" and \""+idRateLimit+"\" cannot be negative.")
+	}
+
+	if RateLimit > 0 && RateBurst <= 0 {
+		msglist = append(msglist, "\""+idRateBurst+"\" must be positive.")
+	}
+
+	if PingInterval <= 0 || WriteTimeout <= 0 {
+		msglist = append(msglist, "\""+idPingInterval+"\" and \""+idWriteTimeout+"\" must be positive.")
+	}
+
+	if PongTimeout <= PingInterval {
+		msglist = append(msglist, "\""+idPongTimeout+"\" must be longer than \""+idPingInterval+"\".")
+	}
+
+	if SendBuffer <= 0 {
+		msglist = append(msglist, "\""+idSendBuffer+"\" must be positive.")
+	}
+
+	if StatsInterval < 0 {
+		msglist = append(msglist, "\""+idStatsInterval+"\" cannot be negative.")
+	}
+
+	switch OverflowPolicy {
+	case OverflowDropOldest, OverflowCoalesce, OverflowDisconnect:
+	default:
+		msglist = append(msglist, "\""+idOverflowPolicy+"\" must be one of "+strings.Join([]string{OverflowDropOldest, OverflowCoalesce, OverflowDisconnect}, ", ")+".")
+	}
+
+	if BuildSlots <= 0 {
+		msglist = append(msglist, "\""+idBuildSlots+"\" must be positive.")
+	}
+
 	return
 }
@@ -4,6 +4,7 @@ import (
 	"comm"
 	"config"
 	"encoding/json"
+	"errors"
 	"game/player"
 	"game/world"
 	"io/ioutil"
@@ -23,6 +24,10 @@ type ClientInfo struct {
 type GameDB struct {
 	playerDB *player.PlayerDB
 	worldDB  *world.WorldDB
+	moveDB   *world.MovementDB
+
+	scheduler *Scheduler
+	clock     util.Clock // Game time, may run faster than system time
 }
 
 // Must use refrence type
@@ -32,11 +37,14 @@ type CommonData struct {
 	client2Chunks  map[ClientInfo][]util.Point // store chunks where the client is watching
 	owner_changed  chan string
 	minimap        *MinimapData
+	client2Session map[ClientInfo]string   // store session ID of the client
+	sessions       map[string]*sessionView // store viewport of sessions for resuming
 
 	onlineLock  *sync.RWMutex
 	chunkLock   *sync.RWMutex
 	clientLock  *sync.RWMutex
 	minimapLock *sync.RWMutex
+	sessionLock *sync.Mutex
 }
 
 type GameEngine struct {
@@ -48,7 +56,7 @@ type GameEngine struct {
 	mbus     *comm.MBusNode
 }
 
-func NewGameEngine() (engine *GameEngine, err error) {
+func NewGameEngine(clock util.Clock) (engine *GameEngine, err error) {
 	playerDB, err := player.NewPlayerDB(path.Join(config.DBDir, "pdb"))
 	if err != nil {
 		return
@@ -59,7 +67,17 @@ func NewGameEngine() (engine *GameEngine, err error) {
 		return
 	}
 
-	gameDB := GameDB{playerDB, worldDB}
+	moveDB, err := world.NewMovementDB(path.Join(config.DBDir, "mdb"))
+	if err != nil {
+		return
+	}
+
+	scheduler, err := NewScheduler(path.Join(config.DBDir, "edb"), clock)
+	if err != nil {
+		return
+	}
+
+	gameDB := GameDB{playerDB, worldDB, moveDB, scheduler, clock}
 
 	online_players := make(map[string]chan<- string)
 	chunk2Clients := make(map[util.Point][]ClientInfo)
@@ -74,10 +92,13 @@ func NewGameEngine() (engine *GameEngine, err error) {
 		client2Chunks,
 		owner_changed,
 		&mmap_data,
+		make(map[ClientInfo]string),
+		make(map[string]*sessionView),
 		new(sync.RWMutex),
 		new(sync.RWMutex),
 		new(sync.RWMutex),
 		new(sync.RWMutex),
+		new(sync.Mutex),
 	}
 
 	mbus, err := comm.NewMBusNode("game")
@@ -96,7 +117,13 @@ func (engine GameEngine) Start() {
 	log.Println("[INFO] Starting game engine")
 	rand.Seed(time.Now().UTC().UnixNano())
 
-	// initialize minimap data & run timer for unfinished structure operation
+	// Events already scheduled, used to find unfinished operations without event
+	pending, err := engine.scheduler.PendingKeys()
+	if err != nil {
+		log.Fatalln("[ERROR] Event data corrupted")
+	}
+
+	// initialize minimap data & resume unfinished structure operations
 	log.Println("[INFO] Initializing map data")
 	engine.minimap.Size = util.Size{50, 50}
 	engine.minimap.Owner = make([][]string, 50)
@@ -125,46 +152,91 @@ func (engine GameEngine) Start() {
 				for k, v := range t_map {
 					if v > t_num {
 						t_most = k
+						t_num = v
 					}
 				}
 				return t_most
 			}()
 
-			// ->unfinished-> structures
-			for _, s := range chk.Structures {
-				currentTime := time.Now().Unix()
-				if s.Status == world.Building || s.Status == world.Destructing {
-					if s.UpdateTime+s.BuildTime <= currentTime {
-						go UpdateChunk(engine.GameDB, chk.Owner, chk.Key())
-					} else {
-						go func() {
-							select {
-							case <-time.After(time.Duration(s.BuildTime-(currentTime-s.UpdateTime)) * time.Second):
-								UpdateChunk(engine.GameDB, chk.Owner, chk.Key())
-							}
-						}()
-					}
-				}
-			}
+			engine.resumeChunk(chk, pending)
+		}
+	}
+
+	// troops still moving
+	movements, err := engine.moveDB.List()
+	if err != nil {
+		log.Fatalln("[ERROR] Movement data corrupted")
+	}
+
+	for _, mv := range movements {
+		if !pending[MovementEvent][mv.ID] {
+			engine.handler.scheduleMovement(mv)
 		}
 	}
 
+	// Unfinished structure operations & moving troops are resumed by events
+	log.Println("[INFO] Starting scheduler")
+	engine.scheduler.Handle(ChunkUpdateEvent, func(ev Event) {
+		UpdateChunk(engine.GameDB, ev.Username, ev.Key)
+	})
+	engine.scheduler.Handle(MovementEvent, func(ev Event) {
+		if mv, err := engine.moveDB.Get(ev.Key); err == nil {
+			engine.handler.arrive(mv)
+		}
+	})
+	engine.scheduler.Handle(BonusUpdateEvent, func(ev Event) {
+		UpdateBonuses(engine.GameDB, ev.Key)
+	})
+	engine.scheduler.Handle(PopulationEvent, func(ev Event) {
+		engine.UpdatePopulation()
+		engine.schedulePopulation()
+	})
+
+	if events, err := engine.scheduler.Pending(PopulationEvent); err != nil {
+		log.Fatalln("[ERROR] Event data corrupted")
+	} else if len(events) == 0 {
+		engine.schedulePopulation()
+	}
+
+	engine.scheduler.Start()
+
 	log.Println("[INFO] Starting message handler")
 	engine.handler.start()
 
 	log.Println("[INFO] Starting notifier")
 	engine.notifier.start()
 
-	log.Println("[INFO] Starting population updater")
-	go func() {
-		for {
-			// Update every 10 min
-			<-time.After(time.Until(time.Now().Add(time.Second * 2).Truncate(time.Second * 2)))
-			engine.UpdatePopulation()
+	log.Println("[INFO] Game engine service available")
+}
+
+// Schedule updates of unfinished structure operations on the chunk if the
+// chunk has no update scheduled. Events are missing for DB created before
+// events were persisted, or event lost by crash while it was handled
+func (engine GameEngine) resumeChunk(chk world.Chunk, pending map[EventType]map[string]bool) {
+	if pending[ChunkUpdateEvent][chk.Key()] {
+		return
+	}
+
+	currentTime := engine.clock.Now().Unix()
+
+	for _, s := range chk.Structures {
+		if s.Status == world.Building || s.Status == world.Destructing {
+			ScheduleChunkUpdate(engine.GameDB, chk.Owner, chk.Key(), s.UpdateTime+s.BuildTime-currentTime)
 		}
-	}()
+	}
 
-	log.Println("[INFO] Game engine service available")
+	// Queued structures start if build slots are available
+	if len(chk.Queue) > 0 {
+		ScheduleChunkUpdate(engine.GameDB, chk.Owner, chk.Key(), 0)
+	}
+}
+
+// Update population at the next 2 seconds boundary
+func (engine GameEngine) schedulePopulation() {
+	next := engine.clock.Now().Add(time.Second * 2).Truncate(time.Second * 2)
+	if err := engine.scheduler.Schedule(Event{Type: PopulationEvent, Time: next.Unix()}); err != nil {
+		log.Println("[ERROR]", err)
+	}
 }
 
 func (engine GameEngine) LoadTerrain(from util.Point, to util.Point, filename string) (err error) {
@@ -178,7 +250,7 @@ func (engine GameEngine) LoadTerrain(from util.Point, to util.Point, filename st
 	for _, p := range util.InRange(from, to) {
 		chunk, err := engine.worldDB.Get(p.String())
 		if err != nil {
-			chunk = *world.NewChunk(p)
+			chunk = *world.NewChunk(p, engine.clock.Now().Unix())
 		}
 
 		for i := 0; uint(i) < world.ChunkSize.W; i++ {
@@ -226,6 +298,10 @@ func (engine GameEngine) UpdatePopulation() {
 				owner.Population = owner.PopulationCap
 			}
 
+			// Train troops by military structures
+			chunk.Troops += chunk.TroopRate()
+			owner.Troops += chunk.TroopRate()
+
 			engine.worldDB.Put(chunk.Key(), chunk)
 			engine.worldDB.Unlock(pos.String())
 		}
@@ -237,13 +313,26 @@ func (engine GameEngine) UpdatePopulation() {
 
 func UpdateChunk(db GameDB, username string, key string) (err error) {
 	var owner player.Player
-	currentTime := time.Now().Unix()
+	currentTime := db.clock.Now().Unix()
+
+	// Peek chunks covered by structures being destructed, since structures
+	// may span chunk borders, chunks are locked together in order
+	keys := []string{key}
+	if chunk, err := db.worldDB.Get(key); err == nil {
+		for _, s := range chunk.Structures {
+			if s.Status == world.Destructing {
+				for pos := range world.Footprint(s) {
+					keys = append(keys, pos.String())
+				}
+			}
+		}
+	}
 
 	db.playerDB.Lock(username)
 	defer db.playerDB.Unlock(username)
 
-	db.worldDB.Lock(key)
-	defer db.worldDB.Unlock(key)
+	db.worldDB.LockAll(keys)
+	defer db.worldDB.UnlockAll(keys)
 
 	chunk, err := db.worldDB.Get(key)
 	if err != nil {
@@ -255,10 +344,25 @@ func UpdateChunk(db GameDB, username string, key string) (err error) {
 		return
 	}
 
+	area := world.Area{chunk.Pos: &chunk}
+	for _, k := range keys {
+		if k == key {
+			continue
+		}
+
+		c, err := db.worldDB.Get(k)
+		if err != nil {
+			log.Println("[WARNING]", err)
+			continue
+		}
+
+		area[c.Pos] = &c
+	}
+
 	need_update := func() []world.Structure {
 		var res []world.Structure
 		for _, s := range chunk.Structures {
-			if s.UpdateTime+s.BuildTime <= currentTime {
+			if s.Status != world.Queued && s.UpdateTime+s.BuildTime <= currentTime {
 				res = append(res, s)
 			}
 		}
@@ -272,6 +376,9 @@ func UpdateChunk(db GameDB, username string, key string) (err error) {
 			return
 		}
 
+		// Resources produced before rates changed
+		owner.Update(currentTime)
+
 		for _, s := range need_update {
 			index, _ := world.GetStructure(chunk, s)
 			chunk.Structures[index].UpdateTime = currentTime
@@ -285,8 +392,8 @@ func UpdateChunk(db GameDB, username string, key string) (err error) {
 					owner.Power += int64(-(s.Power))
 				}
 
-				// Calculate money
-				owner.MoneyRate += int64(s.Money)
+				// Calculate resources
+				owner.AddRates(s.Rates(), 1)
 
 				// Calculate population
 				owner.PopulationCap += int64(s.PopulationCap)
@@ -295,10 +402,15 @@ func UpdateChunk(db GameDB, username string, key string) (err error) {
 					chunk.PopulationRate += int64(s.Population)
 				}
 				chunk.Structures[index].Status = world.Running
+
+				// Build slot freed for queued structures
+				chunk.Dequeue(s.Pos)
 			case world.Destructing:
-				err = world.DestructStructure(&chunk, s)
-				if err != nil {
-					return
+				// Chunks not locked, structure started destructing after peeking
+				// will be updated by its own timer
+				if err := world.DestructStructure(area, s); err != nil {
+					log.Println("[WARNING]", err)
+					continue
 				}
 
 				if s.Power > 0 {
@@ -307,7 +419,7 @@ func UpdateChunk(db GameDB, username string, key string) (err error) {
 					owner.Power -= int64(-(s.Power))
 				}
 
-				owner.MoneyRate -= int64(s.Money)
+				owner.AddRates(s.Rates(), -1)
 
 				if s.Population > 0 {
 					chunk.PopulationRate -= int64(s.Population)
@@ -316,71 +428,289 @@ func UpdateChunk(db GameDB, username string, key string) (err error) {
 				// Change max population
 				owner.PopulationCap -= int64(s.PopulationCap)
 
-				// Money back when destruct
-				// TODO: calculate upgrade money
-				owner.Money += int64(s.Cost) / 2
+				// Money back when destruct, money spent on all levels
+				// equals to the cost of next upgrade
+				owner.Resources[world.Money] += int64(s.UpgradeCost()) / 2
 			}
 		}
 
+		// Structures around may get or lose bonus
+		refreshBonuses(db, &owner, username, area)
+
 		db.playerDB.Put(username, owner)
-		db.worldDB.Put(chunk.Key(), chunk)
+	}
+
+	// Start queued structures on free build slots
+	started := chunk.StartQueued(config.BuildSlots, currentTime)
+	for _, s := range started {
+		ScheduleChunkUpdate(db, username, key, s.BuildTime)
+	}
+
+	if len(need_update) > 0 || len(started) > 0 {
+		db.worldDB.PutAll(area.Chunks())
 	}
 
 	return nil
 }
 
-// TODO: return error
-func HaltPlayer(db GameDB, username string) {
+// Update structures of the chunk after seconds
+func ScheduleChunkUpdate(db GameDB, username string, key string, seconds int64) {
+	if err := db.scheduler.After(seconds, Event{Type: ChunkUpdateEvent, Username: username, Key: key}); err != nil {
+		log.Println("[ERROR]", err)
+	}
+}
+
+// Add functions of a running structure to its owner & chunk
+func startStructure(owner *player.Player, chunk *world.Chunk, str world.Structure) {
+	if str.Power > 0 {
+		owner.PowerMax += int64(str.Power)
+	} else {
+		owner.Power += int64(-(str.Power))
+	}
+
+	owner.AddRates(str.Rates(), 1)
+
+	if str.Population > 0 {
+		chunk.PopulationRate += int64(str.Population)
+	}
+
+	owner.PopulationCap += int64(str.PopulationCap)
+}
+
+// Remove functions of a running structure from its owner & chunk
+func stopStructure(owner *player.Player, chunk *world.Chunk, str world.Structure) {
+	if str.Power > 0 {
+		owner.PowerMax -= int64(str.Power)
+	} else {
+		owner.Power -= int64(-(str.Power))
+	}
+
+	owner.AddRates(str.Rates(), -1)
+
+	if str.Population > 0 {
+		chunk.PopulationRate -= int64(str.Population)
+	}
+
+	owner.PopulationCap -= int64(str.PopulationCap)
+}
+
+// Recompute bonuses of running structures owned by the player in the area,
+// caller should hold the locks of the chunks and the player. Structures on
+// chunks nearby are updated by events, since their owners are not locked
+func refreshBonuses(db GameDB, owner *player.Player, username string, area world.Area) {
+	applyBonuses(db, owner, username, area)
+
+	var strs []world.Structure
+	for _, chunk := range area {
+		strs = append(strs, chunk.Structures...)
+	}
+
+	ScheduleBonusUpdates(db, area, strs)
+}
+
+// Schedule bonus update of chunks which may have structures getting bonus
+// from the structures, chunks in the area are skipped
+func ScheduleBonusUpdates(db GameDB, area world.Area, strs []world.Structure) {
+	scheduled := make(map[util.Point]bool)
+	for _, str := range strs {
+		for _, pos := range world.AffectedChunks(str) {
+			if _, ok := area[pos]; ok || scheduled[pos] || !world.InWorld(pos) {
+				continue
+			}
+
+			scheduled[pos] = true
+			if err := db.scheduler.After(0, Event{Type: BonusUpdateEvent, Key: pos.String()}); err != nil {
+				log.Println("[ERROR]", err)
+			}
+		}
+	}
+}
+
+// Recompute bonuses of structures on the chunk, structures nearby have
+// started or stopped running
+func UpdateBonuses(db GameDB, key string) {
+	// Peek owner of the chunk, checked again after locked
+	chunk, err := db.worldDB.Get(key)
+	if err != nil || chunk.Owner == "" {
+		return
+	}
+	username := chunk.Owner
+
 	db.playerDB.Lock(username)
+	defer db.playerDB.Unlock(username)
+
+	db.worldDB.Lock(key)
+	defer db.worldDB.Unlock(key)
+
+	if chunk, err = db.worldDB.Get(key); err != nil || chunk.Owner != username {
+		return
+	}
 
 	owner, err := db.playerDB.Get(username)
 	if err != nil {
+		log.Println("[ERROR]", err)
 		return
 	}
 
-	owner.Update()
+	// Resources produced before rates changed
+	owner.Update(db.clock.Now().Unix())
 
-	if owner.Money < 0 {
-		owner.Money = 0
+	// Only write DB on changed, bonuses of the chunk don't affect others
+	if applyBonuses(db, &owner, username, world.Area{chunk.Pos: &chunk}) {
+		db.worldDB.Put(key, chunk)
+		db.playerDB.Put(username, owner)
 	}
+}
 
-	for _, pos := range owner.Territory {
-		db.worldDB.Lock(pos.String())
+// Recompute bonuses of running structures owned by the player in the area,
+// caller should hold the locks of the chunks and the player. Chunks around
+// the area are read without lock, so bonuses near the border don't depend on
+// which chunks are locked. Returns true if any structure changed
+func applyBonuses(db GameDB, owner *player.Player, username string, area world.Area) (changed bool) {
+	view := make(world.Area)
+	for pos, chunk := range area {
+		view[pos] = chunk
+	}
 
-		chunk, err := db.worldDB.Get(pos.String())
-		if err != nil {
-			db.worldDB.Unlock(pos.String())
-			log.Println("[WARNING]", err)
+	for _, chunk := range area {
+		if chunk.Owner != username {
 			continue
 		}
 
-		for s_index, str := range chunk.Structures {
-			if str.Status == world.Running {
-				chunk.Structures[s_index].Status = world.Halted
+		for index, str := range chunk.Structures {
+			if str.Status != world.Running {
+				continue
+			}
 
-				// Stop functions
-				if str.Power > 0 {
-					owner.PowerMax -= int64(str.Power)
-				} else {
-					owner.Power -= int64(-(str.Power))
+			for _, pos := range world.BonusChunks(str) {
+				if _, ok := view[pos]; ok {
+					continue
 				}
 
-				owner.MoneyRate -= int64(str.Money)
-
-				if str.Population > 0 {
-					chunk.PopulationRate -= int64(str.Population)
+				if around, err := db.worldDB.Get(pos.String()); err == nil {
+					view[pos] = &around
 				}
+			}
 
-				owner.PopulationCap -= int64(str.PopulationCap)
+			updated := str
+			view.ApplyBonus(&updated)
+
+			if updated.SameOutput(str) {
+				continue
 			}
+
+			stopStructure(owner, chunk, str)
+			startStructure(owner, chunk, updated)
+			chunk.Structures[index] = updated
+			changed = true
 		}
+	}
 
-		db.worldDB.Put(chunk.Key(), chunk)
-		db.worldDB.Unlock(pos.String())
+	return
+}
+
+// Destroy structures on the chunk after war, caller should hold the locks of
+// the chunk and its owner
+func DestroyStructures(owner *player.Player, chunk *world.Chunk, current int64) {
+	// Construction stops after war
+	chunk.Queue = nil
+
+	for _, str := range chunk.Structures {
+		destroyStructure(owner, chunk, str, current)
 	}
+}
+
+// Destroy structures on the chunk which extend into the lost chunk, caller
+// should hold the locks of both chunks and the owner. Returns true if any
+// structure is destroyed
+func DestroyCovering(owner *player.Player, chunk *world.Chunk, lost util.Point, current int64) (changed bool) {
+	for _, str := range chunk.Structures {
+		if world.Covers(str, lost) && destroyStructure(owner, chunk, str, current) {
+			changed = true
+		}
+	}
+
+	return
+}
 
-	db.playerDB.Put(username, owner)
-	db.playerDB.Unlock(username)
+// Remove structures on the captured chunk which extend into other chunks,
+// so that blocks of the neighbours are freed and no one can repair them.
+// Structures should be destroyed already, caller should hold the locks of
+// the chunks in the area, which should contain all chunks they cover
+func RemoveSpanning(area world.Area, captured util.Point) (err error) {
+	chunk, ok := area[captured]
+	if !ok {
+		return errors.New("Chunk not available")
+	}
+
+	var spanning []world.Structure
+	for _, str := range chunk.Structures {
+		if len(world.Footprint(str)) > 1 {
+			spanning = append(spanning, str)
+		}
+	}
+
+	for _, str := range spanning {
+		if err = world.DestructStructure(area, str); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Destroy the structure and remove its functions, returns false if it's
+// already destroyed
+func destroyStructure(owner *player.Player, chunk *world.Chunk, str world.Structure, current int64) bool {
+	if err := world.DestroyStructure(chunk, str, current); err != nil {
+		return false
+	}
+
+	// Destroyed structure stops providing anything, structures being
+	// destructed keep providing until destruction finished
+	if str.Status == world.Running || str.Status == world.Destructing {
+		stopStructure(owner, chunk, str)
+	}
+
+	return true
+}
+
+// Attack defender's chunk with troops arrived, caller should hold the locks of
+// both players and the chunk. Attacker occupies the chunk only when all
+// defenders are defeated, otherwise survivors should go back.
+func Attack(attacker, defender *player.Player, chunk_to *world.Chunk, amount int64, current int64) (result BattleResult) {
+	defense := chunk_to.Defense()
+
+	result.Chunk = chunk_to.Pos
+	result.AttackerTroop = amount
+	result.DefenderTroop = chunk_to.Troops + defense
+
+	remainAtk, remainDef := Battle(int(result.AttackerTroop), int(result.DefenderTroop))
+	result.AttackerRemain, result.DefenderRemain = int64(remainAtk), int64(remainDef)
+	result.AttackerWin = remainDef == 0 && remainAtk > 0
+
+	attacker.Troops -= amount - result.AttackerRemain
+
+	if result.AttackerWin {
+		// All troops and population on the chunk are lost
+		defender.Troops -= chunk_to.Troops
+		defender.Population -= chunk_to.Population
+		defender.RemoveTerritory(chunk_to.Pos)
+
+		DestroyStructures(defender, chunk_to, current)
+
+		chunk_to.Troops = result.AttackerRemain
+		chunk_to.Population = 0
+		return
+	}
+
+	// Military structures take damage before troops
+	if lost := result.DefenderTroop - result.DefenderRemain - defense; lost > 0 {
+		chunk_to.Troops -= lost
+		defender.Troops -= lost
+	}
+
+	return
 }
 
 // TODO: return error
@@ -394,7 +724,7 @@ func HaltChunk(db GameDB, username string, key string) {
 		return
 	}
 
-	owner.Update()
+	owner.Update(db.clock.Now().Unix())
 
 	db.worldDB.Lock(key)
 	defer db.worldDB.Unlock(key)
@@ -418,7 +748,7 @@ func HaltChunk(db GameDB, username string, key string) {
 				owner.Power -= int64(-(str.Power))
 			}
 
-			owner.MoneyRate -= int64(str.Money)
+			owner.AddRates(str.Rates(), -1)
 
 			if str.Population > 0 {
 				chunk.PopulationRate -= int64(str.Population)
@@ -0,0 +1,219 @@
+package game
+
+import (
+	"game/player"
+	"game/world"
+	"testing"
+	"time"
+	"util"
+)
+
+func TestResumeChunkSchedulesMissingUpdate(t *testing.T) {
+	clock := util.NewManualClock(time.Unix(1000000, 0))
+	db := newTestDB(t, clock)
+	engine := GameEngine{GameDB: db}
+
+	setStructMap(t, map[int]world.Structure{1: {BuildTime: 60}})
+
+	pos := util.Point{X: 1, Y: 2}
+	chunk := world.NewChunk(pos, clock.Now().Unix())
+	chunk.Owner = "alice"
+
+	str := runningStructure(1, pos, util.Point{X: 0, Y: 0})
+	str.Status = world.Building
+	str.UpdateTime = clock.Now().Unix() - 20
+	chunk.Structures = append(chunk.Structures, str)
+
+	pending, err := db.scheduler.PendingKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.resumeChunk(*chunk, pending)
+
+	events, err := db.scheduler.Pending(ChunkUpdateEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Key != chunk.Key() || events[0].Time != clock.Now().Unix()+40 {
+		t.Fatalf("pending %v, want update of %s after 40 seconds", events, chunk.Key())
+	}
+
+	// Chunk with update scheduled is skipped
+	if pending, err = db.scheduler.PendingKeys(); err != nil {
+		t.Fatal(err)
+	}
+	engine.resumeChunk(*chunk, pending)
+
+	if events, _ = db.scheduler.Pending(ChunkUpdateEvent); len(events) != 1 {
+		t.Fatalf("%d updates pending, want 1", len(events))
+	}
+}
+
+func TestUpdateChunkCompletesBuilding(t *testing.T) {
+	clock := util.NewManualClock(time.Unix(1000000, 0))
+	db := newTestDB(t, clock)
+
+	setStructMap(t, map[int]world.Structure{
+		1: {Power: 100, Produce: map[string]int{world.Money: 10}, PopulationCap: 20, BuildTime: 30},
+	})
+
+	pos := util.Point{X: 0, Y: 0}
+	chunk := world.NewChunk(pos, clock.Now().Unix())
+	chunk.Owner = "alice"
+
+	str := runningStructure(1, pos, util.Point{X: 0, Y: 0})
+	str.Status = world.Building
+	str.UpdateTime = clock.Now().Unix()
+	chunk.Structures = append(chunk.Structures, str)
+	chunk.Enqueue(str.Pos)
+
+	owner := player.Player{
+		Territory:  []util.Point{pos},
+		Resources:  map[string]int64{world.Money: 1000},
+		Rates:      map[string]int64{world.Money: 1},
+		UpdateTime: clock.Now().Unix(),
+	}
+
+	if err := db.worldDB.Put(chunk.Key(), *chunk); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.playerDB.Put("alice", owner); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not finished yet
+	clock.Advance(29 * time.Second)
+	if err := UpdateChunk(db, "alice", chunk.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if updated, _ := db.worldDB.Get(chunk.Key()); updated.Structures[0].Status != world.Building {
+		t.Fatalf("status %v before build time passed", updated.Structures[0].Status)
+	}
+
+	clock.Advance(time.Second)
+	if err := UpdateChunk(db, "alice", chunk.Key()); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := db.worldDB.Get(chunk.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Structures[0].Status != world.Running || len(updated.Queue) != 0 {
+		t.Fatalf("status %v, queue %v after build time passed", updated.Structures[0].Status, updated.Queue)
+	}
+
+	owner, err = db.playerDB.Get("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Resources produced before completed use the old rate
+	if owner.Resources[world.Money] != 1030 || owner.Rates[world.Money] != 11 {
+		t.Errorf("money %d at rate %d, want 1030 at rate 11", owner.Resources[world.Money], owner.Rates[world.Money])
+	}
+	if owner.PowerMax != 100 || owner.PopulationCap != 20 {
+		t.Errorf("power max %d, population cap %d, want 100 & 20", owner.PowerMax, owner.PopulationCap)
+	}
+}
+
+func TestRemoveSpanningFreesBlocksNearby(t *testing.T) {
+	setStructMap(t, map[int]world.Structure{1: {Size: util.Size{W: 2, H: 2}}})
+
+	home := util.Point{X: 0, Y: 0}
+	area := world.Area{}
+	for _, pos := range world.ReachChunks(home) {
+		area[pos] = world.NewChunk(pos, 0)
+	}
+
+	last := int(world.ChunkSize.W) - 1
+	inside := runningStructure(1, home, util.Point{X: 0, Y: 0})
+	spanning := runningStructure(1, home, util.Point{X: last, Y: last})
+	for _, str := range []world.Structure{inside, spanning} {
+		for pos, blocks := range world.Footprint(str) {
+			for _, block := range blocks {
+				area[pos].Blocks[block.X][block.Y].Empty = false
+			}
+		}
+		area[home].Structures = append(area[home].Structures, str)
+	}
+
+	if err := RemoveSpanning(area, home); err != nil {
+		t.Fatal(err)
+	}
+
+	if structs := area[home].Structures; len(structs) != 1 || structs[0].Pos != inside.Pos {
+		t.Fatalf("structures %v, want only the one inside the chunk", structs)
+	}
+
+	for pos, blocks := range world.Footprint(spanning) {
+		for _, block := range blocks {
+			if !area[pos].Blocks[block.X][block.Y].Empty {
+				t.Errorf("block %s of chunk %s still occupied", block.String(), pos.String())
+			}
+		}
+	}
+}
+
+func TestBonusUpdatedOnChunkNearby(t *testing.T) {
+	clock := util.NewManualClock(time.Unix(1000000, 0))
+	db := newTestDB(t, clock)
+	db.scheduler.Handle(BonusUpdateEvent, func(ev Event) {
+		UpdateBonuses(db, ev.Key)
+	})
+
+	setStructMap(t, map[int]world.Structure{
+		1: {Bonuses: []world.Bonus{{Structure: 2, Radius: 1, Produce: map[string]int{world.Money: 5}}}},
+		2: {BuildTime: 30},
+	})
+
+	home, next := util.Point{X: 0, Y: 0}, util.Point{X: 1, Y: 0}
+
+	// Structure of alice at the right border gets bonus from bob's structure
+	// being built next to it
+	chunk := world.NewChunk(home, clock.Now().Unix())
+	chunk.Owner = "alice"
+	chunk.Structures = []world.Structure{runningStructure(1, home, util.Point{X: int(world.ChunkSize.W) - 1, Y: 0})}
+
+	other := world.NewChunk(next, clock.Now().Unix())
+	other.Owner = "bob"
+	building := runningStructure(2, next, util.Point{X: 0, Y: 0})
+	building.Status = world.Building
+	building.UpdateTime = clock.Now().Unix()
+	other.Structures = []world.Structure{building}
+
+	if err := db.worldDB.PutAll([]world.Chunk{*chunk, *other}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if err := db.playerDB.Put(name, player.Player{Resources: map[string]int64{world.Money: 0}, UpdateTime: clock.Now().Unix()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clock.Advance(30 * time.Second)
+	if err := UpdateChunk(db, "bob", other.Key()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chunks around bob's structure are updated, including alice's chunk
+	if n := db.scheduler.RunDue(); n == 0 {
+		t.Fatal("no bonus update handled")
+	}
+
+	updated, err := db.worldDB.Get(chunk.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if money := updated.Structures[0].Produce[world.Money]; money != 5 {
+		t.Errorf("structure produces %d money, want 5 from bonus", money)
+	}
+
+	alice, err := db.playerDB.Get("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.Rates[world.Money] != 5 {
+		t.Errorf("money rate %d, want 5", alice.Rates[world.Money])
+	}
+}
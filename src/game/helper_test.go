@@ -0,0 +1,86 @@
+package game
+
+import (
+	"comm"
+	"fmt"
+	"game/player"
+	"game/world"
+	"path"
+	"testing"
+	"util"
+)
+
+// Game DB in a temporary directory, closed when the test finished
+func newTestDB(t *testing.T, clock util.Clock) GameDB {
+	dir := t.TempDir()
+
+	playerDB, err := player.NewPlayerDB(path.Join(dir, "pdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worldDB, err := world.NewWorldDB(path.Join(dir, "wdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moveDB, err := world.NewMovementDB(path.Join(dir, "mdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler, err := NewScheduler(path.Join(dir, "edb"), clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		scheduler.Close()
+		moveDB.Close()
+		worldDB.Close()
+		playerDB.Close()
+	})
+
+	return GameDB{playerDB, worldDB, moveDB, scheduler, clock}
+}
+
+var testNodes int
+
+// Message handler without clients, messages sent to clients are dropped
+func newTestHandler(t *testing.T, db GameDB) MessageHandler {
+	testNodes++
+	mbus, err := comm.NewMBusNode(fmt.Sprintf("test-%d", testNodes))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return MessageHandler{GameDB: db, mbus: mbus}
+}
+
+// Replace structure catalog for the test
+func setStructMap(t *testing.T, structs map[int]world.Structure) {
+	saved := world.StructMap
+	t.Cleanup(func() { world.StructMap = saved })
+
+	world.StructMap = make(map[int]world.Structure)
+	for id, str := range structs {
+		str.ID = id
+		if str.Size == (util.Size{}) {
+			str.Size = util.Size{W: 1, H: 1}
+		}
+		if str.Level == 0 {
+			str.Level = 1
+		}
+		world.StructMap[id] = str
+	}
+}
+
+// Running structure of the catalog placed at the position
+func runningStructure(id int, chunk util.Point, pos util.Point) world.Structure {
+	str := world.StructMap[id]
+	world.CompleteStructure(&str)
+	str.Chunk = chunk
+	str.Pos = pos
+	str.Status = world.Running
+	return str
+}
@@ -0,0 +1,204 @@
+package game
+
+import (
+	"game/player"
+	"game/world"
+	"log"
+	"sort"
+)
+
+// This file is used to shed load on power or resource shortage. Power only
+// flows within grids of connected territory, resources are shared by all
+// structures of the player
+
+// Seconds of consumption kept in stock before restarting shed structures
+const restartReserve = 60
+
+// Structure in the area
+type structRef struct {
+	chunk *world.Chunk
+	index int
+}
+
+func (ref structRef) get() world.Structure {
+	return ref.chunk.Structures[ref.index]
+}
+
+// Structures in the area with the status, sorted by priority. Latest started
+// structure goes first for the same priority
+func collectStructures(area world.Area, status world.SStatus, ascending bool) (refs []structRef) {
+	for _, chunk := range area {
+		for index, str := range chunk.Structures {
+			if str.Status == status {
+				refs = append(refs, structRef{chunk, index})
+			}
+		}
+	}
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		a, b := refs[i].get(), refs[j].get()
+		if a.Priority != b.Priority {
+			return (a.Priority < b.Priority) == ascending
+		}
+		return a.UpdateTime > b.UpdateTime
+	})
+
+	return
+}
+
+// Check if the structure can run with the power of its grid and the
+// resources of its owner
+func canRestart(owner player.Player, grid_power map[*world.Chunk]*[2]int64, ref structRef) bool {
+	str := ref.get()
+
+	if power := grid_power[ref.chunk]; str.Power < 0 && power[0]-power[1] < int64(-str.Power) {
+		return false
+	}
+
+	for name, rate := range str.Rates() {
+		if rate >= 0 {
+			continue
+		}
+
+		total := owner.Rates[name] + rate
+		if total < 0 && owner.Resources[name]+total*restartReserve < 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Shed the minimum set of low priority structures on shortage, and restart
+// shed structures with high priority first when power & resources recover.
+// Structures halted by other reasons are not restarted
+func BalanceLoad(db GameDB, username string) {
+	db.playerDB.Lock(username)
+	defer db.playerDB.Unlock(username)
+
+	owner, err := db.playerDB.Get(username)
+	if err != nil {
+		log.Println("[WARNING]", err)
+		return
+	}
+
+	owner.Update(db.clock.Now().Unix())
+
+	var keys []string
+	for _, pos := range owner.Territory {
+		keys = append(keys, pos.String())
+	}
+
+	db.worldDB.LockAll(keys)
+	defer db.worldDB.UnlockAll(keys)
+
+	area := make(world.Area)
+	for _, key := range keys {
+		chunk, err := db.worldDB.Get(key)
+		if err != nil {
+			log.Println("[WARNING]", err)
+			continue
+		}
+
+		if chunk.Owner == username {
+			area[chunk.Pos] = &chunk
+		}
+	}
+
+	// Generated & consumed power of grids, shared by chunks of the same grid
+	grid_power := make(map[*world.Chunk]*[2]int64)
+	for _, grid := range owner.Grids() {
+		power := new([2]int64)
+		for _, pos := range grid {
+			chunk, ok := area[pos]
+			if !ok {
+				continue
+			}
+
+			for _, str := range chunk.Structures {
+				if str.Status != world.Running {
+					continue
+				}
+
+				if str.Power > 0 {
+					power[0] += int64(str.Power)
+				} else {
+					power[1] += int64(-str.Power)
+				}
+			}
+
+			grid_power[chunk] = power
+		}
+	}
+
+	var status_changed bool = false
+
+	// Set structure status and update power of its grid
+	setStatus := func(ref structRef, status world.SStatus) {
+		str := ref.get()
+		power := grid_power[ref.chunk]
+
+		if status == world.Running {
+			startStructure(&owner, ref.chunk, str)
+		} else {
+			stopStructure(&owner, ref.chunk, str)
+		}
+
+		sign := int64(1)
+		if status != world.Running {
+			sign = -1
+		}
+
+		if str.Power > 0 {
+			power[0] += sign * int64(str.Power)
+		} else {
+			power[1] += sign * int64(-str.Power)
+		}
+
+		ref.chunk.Structures[ref.index].Status = status
+		status_changed = true
+	}
+
+	// Restart shed structures if they fit
+	for _, ref := range collectStructures(area, world.Shed, false) {
+		if canRestart(owner, grid_power, ref) {
+			setStatus(ref, world.Running)
+		}
+	}
+
+	// Shed consumers of grids consuming more power than generated
+	for _, ref := range collectStructures(area, world.Running, true) {
+		power := grid_power[ref.chunk]
+		if str := ref.get(); str.Power < 0 && power[1] > power[0] {
+			setStatus(ref, world.Shed)
+		}
+	}
+
+	// Shed consumers of resources running out until the stock stops decreasing
+	for name, amount := range owner.Resources {
+		if amount >= 0 {
+			continue
+		}
+
+		owner.Resources[name] = 0
+		status_changed = true
+
+		for _, ref := range collectStructures(area, world.Running, true) {
+			if owner.Rates[name] >= 0 {
+				break
+			}
+
+			if ref.get().Rates()[name] < 0 {
+				setStatus(ref, world.Shed)
+			}
+		}
+	}
+
+	// Only write DB on changed to prevent shedding loop
+	if status_changed {
+		refreshBonuses(db, &owner, username, area)
+
+		db.worldDB.PutAll(area.Chunks())
+		db.playerDB.Put(username, owner)
+	}
+}
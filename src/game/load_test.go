@@ -0,0 +1,75 @@
+package game
+
+import (
+	"comm"
+	"encoding/json"
+	"game/player"
+	"game/world"
+	"testing"
+	"time"
+	"util"
+)
+
+func TestPrioritizeChangesShedOrder(t *testing.T) {
+	clock := util.NewManualClock(time.Unix(1000000, 0))
+	db := newTestDB(t, clock)
+	mHandler := newTestHandler(t, db)
+
+	setStructMap(t, map[int]world.Structure{
+		1: {Power: 100},
+		2: {Power: -60, Priority: 1},
+		3: {Power: -60, Priority: 2},
+	})
+
+	home := util.Point{X: 0, Y: 0}
+	chunk := world.NewChunk(home, clock.Now().Unix())
+	chunk.Owner = "alice"
+	chunk.Structures = []world.Structure{
+		runningStructure(1, home, util.Point{X: 0, Y: 0}),
+		runningStructure(2, home, util.Point{X: 1, Y: 0}),
+		runningStructure(3, home, util.Point{X: 2, Y: 0}),
+	}
+
+	owner := player.Player{
+		Territory:  []util.Point{home},
+		Resources:  map[string]int64{world.Money: 1000},
+		Power:      120,
+		PowerMax:   100,
+		UpdateTime: clock.Now().Unix(),
+	}
+
+	if err := db.worldDB.Put(chunk.Key(), *chunk); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.playerDB.Put("alice", owner); err != nil {
+		t.Fatal(err)
+	}
+
+	// Raise priority of structure 2 above structure 3
+	target := chunk.Structures[1]
+	target.Priority = 5
+
+	b, err := json.Marshal(BuildingPayload{comm.Payload{Msg_type: comm.BuildRequest}, Prioritize, target, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mHandler.onBuildRequest(comm.MessageWrapper{Username: "alice", Data: b})
+	BalanceLoad(db, "alice")
+
+	updated, err := db.worldDB.Get(chunk.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []world.SStatus{world.Running, world.Running, world.Shed}
+	for i, str := range updated.Structures {
+		if str.Status != want[i] {
+			t.Errorf("structure %d: status %v, want %v", str.ID, str.Status, want[i])
+		}
+	}
+
+	if updated.Structures[1].Priority != 5 {
+		t.Errorf("priority %d, want 5", updated.Structures[1].Priority)
+	}
+}
@@ -2,12 +2,15 @@ package game
 
 import (
 	"comm"
+	"config"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"game/player"
 	"game/world"
 	"log"
 	"math/rand"
-	"time"
+	"sort"
 	"util"
 )
 
@@ -38,6 +41,8 @@ func NewMessageHandler(gameDB GameDB, common_data CommonData, mbus *comm.MBusNod
 	mHandler.onMessage[comm.BuildRequest] = mHandler.onBuildRequest
 	mHandler.onMessage[comm.OccupyRequest] = mHandler.onOccupyRequest
 	mHandler.onMessage[comm.Message] = mHandler.onBroadcastMessage
+	mHandler.onMessage[comm.StructureCatalogRequest] = mHandler.onStructureCatalogRequest
+	mHandler.onMessage[comm.BulkActionRequest] = mHandler.onBulkActionRequest
 
 	return mHandler
 }
@@ -52,11 +57,69 @@ func (mHandler MessageHandler) start() {
 				continue
 			}
 
-			go mHandler.onMessage[payload.Msg_type](msg_wrapper)
+			on_message, ok := mHandler.onMessage[payload.Msg_type]
+			if !ok {
+				log.Println("[WARNING]", "Unknown message type.")
+				mHandler.sendError(msg_wrapper, comm.InvalidRequest, "Unknown message type.")
+				continue
+			}
+
+			go on_message(msg_wrapper)
 		}
 	}()
 }
 
+// Send error response to the client who sent the request
+func (mHandler MessageHandler) sendError(request comm.MessageWrapper, code comm.ErrorCode, message string) {
+	var req_payload comm.Payload
+
+	// Payload has been unmarshalled once before dispatching, so the message type is valid here
+	json.Unmarshal(request.Data, &req_payload)
+
+	b, err := json.Marshal(comm.ErrorPayload{
+		Payload: comm.Payload{Msg_type: comm.ErrorResponse, Request_id: req_payload.Request_id},
+		Request: req_payload.Msg_type,
+		Code:    code,
+		Message: message,
+	})
+	if err != nil {
+		log.Println("[ERROR]", err)
+		return
+	}
+
+	msg := request
+	msg.SendTo = comm.SendToClient
+	msg.Data = b
+
+	mHandler.mbus.Write("ws", msg)
+}
+
+// Send acknowledgement to the client who sent the request, only if client supplied a request ID
+func (mHandler MessageHandler) sendAck(request comm.MessageWrapper) {
+	var req_payload comm.Payload
+
+	json.Unmarshal(request.Data, &req_payload)
+
+	if req_payload.Request_id == "" {
+		return
+	}
+
+	b, err := json.Marshal(comm.AckPayload{
+		Payload: comm.Payload{Msg_type: comm.AckResponse, Request_id: req_payload.Request_id},
+		Request: req_payload.Msg_type,
+	})
+	if err != nil {
+		log.Println("[ERROR]", err)
+		return
+	}
+
+	msg := request
+	msg.SendTo = comm.SendToClient
+	msg.Data = b
+
+	mHandler.mbus.Write("ws", msg)
+}
+
 func (mHandler MessageHandler) startPlayerDataUpdate(client_info ClientInfo) {
 	username := client_info.username
 
@@ -87,11 +150,22 @@ func (mHandler MessageHandler) startPlayerDataUpdate(client_info ClientInfo) {
 
 func (mHandler MessageHandler) onLoginRequest(request comm.MessageWrapper) {
 	username := request.Username
+	client_info := ClientInfo{request.Cid, request.Username}
+
+	var payload comm.LoginPayload
+	if err := json.Unmarshal(request.Data, &payload); err != nil {
+		log.Println("[ERROR]", err)
+	}
+
+	// Restore viewport of the resumed session
+	if poss := mHandler.openSession(client_info, payload.Session, payload.Resumed); len(poss) > 0 {
+		mHandler.sendMapData(request, comm.Payload{Msg_type: comm.MapDataResponse}, poss)
+	}
 
 	_, err := mHandler.playerDB.Get(username)
 	if err != nil {
 		// Username not found! Send HomePointRequest to client
-		b, err := json.Marshal(comm.Payload{comm.HomePointRequest})
+		b, err := json.Marshal(comm.Payload{Msg_type: comm.HomePointRequest})
 		if err != nil {
 			log.Println("[ERROR]", err)
 		}
@@ -104,7 +178,7 @@ func (mHandler MessageHandler) onLoginRequest(request comm.MessageWrapper) {
 		return
 	}
 
-	mHandler.startPlayerDataUpdate(ClientInfo{request.Cid, request.Username})
+	mHandler.startPlayerDataUpdate(client_info)
 }
 
 func (mHandler MessageHandler) onLogoutRequest(request comm.MessageWrapper) {
@@ -149,6 +223,8 @@ func (mHandler MessageHandler) onLogoutRequest(request comm.MessageWrapper) {
 	mHandler.clientLock.Lock()
 	delete(mHandler.client2Chunks, client_info)
 	mHandler.clientLock.Unlock()
+
+	mHandler.closeSession(client_info)
 }
 
 func (mHandler MessageHandler) onMapDataRequest(request comm.MessageWrapper) {
@@ -159,15 +235,21 @@ func (mHandler MessageHandler) onMapDataRequest(request comm.MessageWrapper) {
 
 	if err := json.Unmarshal(request.Data, &payload); err != nil {
 		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.InvalidRequest, err.Error())
 		return
 	}
 
+	mHandler.sendMapData(request, payload.Payload, payload.ChunkPos)
+}
+
+// Send chunks to the client, and let the client watch these chunks
+func (mHandler MessageHandler) sendMapData(request comm.MessageWrapper, payload comm.Payload, poss []util.Point) {
 	chunks := []world.Chunk{}
 
-	for _, pos := range payload.ChunkPos {
+	for _, pos := range poss {
 		chunk, err := mHandler.worldDB.Get(pos.String())
 		if err != nil {
-			chunk = *world.NewChunk(pos)
+			chunk = *world.NewChunk(pos, mHandler.clock.Now().Unix())
 
 			if err := mHandler.worldDB.Put(pos.String(), chunk); err != nil {
 				log.Println("[ERROR]", err)
@@ -177,12 +259,14 @@ func (mHandler MessageHandler) onMapDataRequest(request comm.MessageWrapper) {
 		chunks = append(chunks, chunk)
 	}
 
+	// Response keeps request ID of the request, no additional ack needed
 	payload.Msg_type = comm.MapDataResponse
-	map_data := MapDataPayload{payload.Payload, chunks}
+	map_data := MapDataPayload{payload, chunks, movementsIn(mHandler.GameDB, poss)}
 
 	b, err := json.Marshal(map_data)
 	if err != nil {
 		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.ServerError, err.Error())
 		return
 	}
 
@@ -214,7 +298,7 @@ func (mHandler MessageHandler) onMapDataRequest(request comm.MessageWrapper) {
 		mHandler.clientLock.RUnlock()
 
 		// Update clients who are watching this chunk
-		for _, pos := range payload.ChunkPos {
+		for _, pos := range poss {
 			mHandler.chunkLock.Lock()
 			if infos, ok := mHandler.chunk2Clients[pos]; !ok {
 				mHandler.chunk2Clients[pos] = []ClientInfo{client_info}
@@ -226,8 +310,10 @@ func (mHandler MessageHandler) onMapDataRequest(request comm.MessageWrapper) {
 
 		// Update where the client is watching
 		mHandler.clientLock.Lock()
-		mHandler.client2Chunks[client_info] = payload.ChunkPos
+		mHandler.client2Chunks[client_info] = poss
 		mHandler.clientLock.Unlock()
+
+		mHandler.saveViewport(client_info, poss)
 	}
 }
 
@@ -237,140 +323,379 @@ func (mHandler MessageHandler) onBuildRequest(request comm.MessageWrapper) {
 
 	if err = json.Unmarshal(request.Data, &payload); err != nil {
 		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.InvalidRequest, err.Error())
 		return
 	}
 
 	log.Printf("[INFO] %s request %s at chunk (%s), pos (%s)", request.Username, string(payload.Action), payload.Structure.Chunk.String(), payload.Structure.Pos.String())
 
-	// Retrieve info from struct definition
+	if _, ok := world.StructMap[payload.Structure.ID]; !ok {
+		log.Println("[INFO] Structure not defined.")
+		mHandler.sendError(request, comm.UnknownStructure, "Structure not defined.")
+		return
+	}
+
+	// Retrieve info from struct definition, priority set by client is kept
+	priority := payload.Structure.Priority
 	world.CompleteStructure(&payload.Structure)
+	if payload.Action == Prioritize {
+		payload.Structure.Priority = priority
+	}
+
+	mHandler.playerDB.Lock(request.Username)
+	defer mHandler.playerDB.Unlock(request.Username)
 
 	user, err := mHandler.playerDB.Get(request.Username)
 	if err != nil {
 		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.PlayerNotFound, err.Error())
 		return
 	}
-	user.Update()
+	user.Update(mHandler.clock.Now().Unix())
 
-	mHandler.playerDB.Lock(request.Username)
-	defer mHandler.playerDB.Unlock(request.Username)
+	// Structure may span chunk borders, lock all chunks it covers so that
+	// user's permission is checked on each of them
+	keys := []string{payload.Structure.Chunk.String()}
+	for pos := range world.Footprint(payload.Structure) {
+		if !world.InWorld(pos) {
+			log.Println("[INFO] Structure out of world.")
+			mHandler.sendError(request, comm.InvalidOperation, "Structure out of world.")
+			return
+		}
 
-	mHandler.worldDB.Lock(payload.Structure.Chunk.String())
-	defer mHandler.worldDB.Unlock(payload.Structure.Chunk.String())
+		keys = append(keys, pos.String())
+	}
+
+	mHandler.worldDB.LockAll(keys)
+	defer mHandler.worldDB.UnlockAll(keys)
 
 	chunk, err := mHandler.worldDB.Get(payload.Structure.Chunk.String())
 	if err != nil {
 		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.ServerError, err.Error())
 		return
 	}
 
+	area := world.Area{chunk.Pos: &chunk}
+	for _, key := range keys {
+		if key == chunk.Key() {
+			continue
+		}
+
+		c, err := mHandler.worldDB.Get(key)
+		if err != nil {
+			log.Println("[ERROR]", err)
+			mHandler.sendError(request, comm.ServerError, err.Error())
+			return
+		}
+
+		area[c.Pos] = &c
+	}
+
 	// Check user's permission
-	if request.Username != chunk.Owner {
-		log.Println("[INFO] User do not own the chunk.")
+	for _, c := range area {
+		if request.Username != c.Owner {
+			log.Println("[INFO] User do not own the chunk.")
+			mHandler.sendError(request, comm.PermissionDenied, "User do not own the chunk.")
+			return
+		}
+	}
+
+	// Check structure exists for actions on built structure
+	var index int
+	if payload.Action != Build {
+		if index, err = world.GetStructure(chunk, payload.Structure); err != nil {
+			log.Println(err)
+			mHandler.sendError(request, comm.StructureNotFound, err.Error())
+			return
+		}
+
+		// Rotation sent by client may differ from the built structure
+		for pos := range world.Footprint(chunk.Structures[index]) {
+			if _, ok := area[pos]; !ok {
+				log.Println("[INFO] Structure rotation mismatch.")
+				mHandler.sendError(request, comm.InvalidOperation, "Structure rotation mismatch.")
+				return
+			}
+		}
+	}
+
+	// Check world status & perform action
+	var code comm.ErrorCode = comm.InvalidOperation
+
+	if payload.Action == Build {
+		// Check user's money
+		if user.Resources[world.Money] < int64(payload.Structure.Cost) {
+			log.Println("User do not have enough money.")
+			mHandler.sendError(request, comm.NotEnoughMoney, "User do not have enough money.")
+			return
+		}
+
+		// Structure waits in construction queue for a free build slot
+		payload.Structure.Status = world.Queued
+		if err = world.BuildStructure(area, payload.Structure, mHandler.clock.Now().Unix()); err == nil {
+			chunk.Enqueue(payload.Structure.Pos)
+			user.Resources[world.Money] -= int64(payload.Structure.Cost)
+		}
+	} else {
+		code, err = mHandler.structureAction(&user, area, &chunk, index, payload)
+	}
+
+	// Handle player & world error
+	if err != nil {
+		log.Println(err)
+		mHandler.sendError(request, code, err.Error())
 		return
 	}
 
+	// Start queued structures on free build slots
+	for _, s := range chunk.StartQueued(config.BuildSlots, mHandler.clock.Now().Unix()) {
+		ScheduleChunkUpdate(mHandler.GameDB, chunk.Owner, chunk.Key(), s.BuildTime)
+	}
+
+	// Check chunk resource status(such as human not enough)
+	human_needed := 0
+	for _, b := range chunk.Structures {
+		human_needed += -b.Population
+	}
+
+	// TODO: Change building status when human not enough
+	//log.Println(human_needed, chunk.Population)
+
+	// Structures around may get or lose bonus
+	refreshBonuses(mHandler.GameDB, &user, request.Username, area)
+
+	// Write data into database if no world error happened
+	mHandler.playerDB.Put(request.Username, user)
+	mHandler.worldDB.PutAll(area.Chunks())
+
+	mHandler.sendAck(request)
+}
+
+// Perform action on a placed structure, caller should hold the locks of the
+// chunks in the area and the player
+func (mHandler MessageHandler) structureAction(user *player.Player, area world.Area, chunk *world.Chunk, index int, payload BuildingPayload) (code comm.ErrorCode, err error) {
+	str := chunk.Structures[index]
+
 	// Check user's money
 	switch payload.Action {
-	case Build:
-		if user.Money < int64(payload.Structure.Cost) {
-			err = errors.New("User do not have enough money.")
+	case Upgrade:
+		if user.Resources[world.Money] < int64(str.UpgradeCost()) {
+			return comm.NotEnoughMoney, errors.New("User do not have enough money.")
+		}
+	case Repair:
+		if user.Resources[world.Money] < int64(str.RepairCost()) {
+			return comm.NotEnoughMoney, errors.New("User do not have enough money.")
 		}
-		//case Upgrade:
 		//case Destruct:
-		//case Repair:
 		//case Restart:
 	}
 
-	// Handle player error
-	if err != nil {
-		log.Println(err)
-		return
-	}
+	code = comm.InvalidOperation
 
 	// Check world status & perform action
 	switch payload.Action {
-	case Build:
-		payload.Structure.Status = world.Building
-		err = world.BuildStructure(&chunk, payload.Structure)
-		user.Money -= int64(payload.Structure.Cost)
-		go func() {
-			select {
-			case <-time.After(time.Duration(world.StructMap[payload.Structure.ID].BuildTime) * time.Second):
-				UpdateChunk(mHandler.GameDB, chunk.Owner, chunk.Key())
-			}
-		}()
-	//case Upgrade:
+	case Upgrade:
+		if err = world.UpgradeStructure(chunk, str, mHandler.clock.Now().Unix()); err != nil {
+			break
+		}
+
+		// Stop functions of running structure during upgrading
+		if str.Status == world.Running {
+			stopStructure(user, chunk, str)
+		}
+
+		user.Resources[world.Money] -= int64(str.UpgradeCost())
+		ScheduleChunkUpdate(mHandler.GameDB, chunk.Owner, chunk.Key(), world.StructMap[str.ID].BuildTime)
 	case Destruct:
-		index, _ := world.GetStructure(chunk, payload.Structure)
+		if str.Status == world.Queued {
+			err = errors.New("Queued structure should be cancelled")
+			break
+		}
+
+		if str.Status == world.Destructing {
+			err = errors.New("Structure is already destructing")
+			break
+		}
 
 		// Set properties to 0 to prevent minus after destruction
-		if chunk.Structures[index].Status == world.Building || chunk.Structures[index].Status == world.Halted {
+		if str.Status == world.Building || str.Status == world.Halted || str.Status == world.Shed || str.Status == world.Destroyed {
 			chunk.Structures[index].Power = 0
-			chunk.Structures[index].Money = 0
+			chunk.Structures[index].Produce = nil
+			chunk.Structures[index].Consume = nil
 			chunk.Structures[index].Population = 0
 			chunk.Structures[index].PopulationCap = 0
 		}
 
-		if chunk.Structures[index].Status != world.Destructing {
-			chunk.Structures[index].Status = world.Destructing
-			chunk.Structures[index].BuildTime = world.StructMap[payload.Structure.ID].BuildTime
-			chunk.Structures[index].UpdateTime = time.Now().Unix()
-			go func() {
-				select {
-				case <-time.After(time.Duration(world.StructMap[payload.Structure.ID].BuildTime) * time.Second):
-					UpdateChunk(mHandler.GameDB, chunk.Owner, chunk.Key())
-				}
-			}()
+		chunk.Structures[index].Status = world.Destructing
+		chunk.Structures[index].BuildTime = world.StructMap[str.ID].BuildTime
+		chunk.Structures[index].UpdateTime = mHandler.clock.Now().Unix()
+		ScheduleChunkUpdate(mHandler.GameDB, chunk.Owner, chunk.Key(), world.StructMap[str.ID].BuildTime)
+	case Repair:
+		if err = world.RepairStructure(chunk, str, mHandler.clock.Now().Unix()); err != nil {
+			break
 		}
-		//case Repair:
-	case Restart:
-		index, _ := world.GetStructure(chunk, payload.Structure)
 
-		if chunk.Structures[index].Status == world.Halted {
-			chunk.Structures[index].Status = world.Running
+		user.Resources[world.Money] -= int64(str.RepairCost())
+		ScheduleChunkUpdate(mHandler.GameDB, chunk.Owner, chunk.Key(), world.StructMap[str.ID].BuildTime)
+	case Restart:
+		if str.Status != world.Halted && str.Status != world.Shed {
+			err = errors.New("Structure is not halted")
+			break
+		}
 
-			// Calcutate power
-			if chunk.Structures[index].Power > 0 {
-				user.PowerMax += int64(chunk.Structures[index].Power)
-			} else {
-				user.Power += int64(-(chunk.Structures[index].Power))
-			}
+		chunk.Structures[index].Status = world.Running
+		startStructure(user, chunk, str)
+	case Prioritize:
+		chunk.Structures[index].Priority = payload.Structure.Priority
+	case Cancel:
+		if str.Status != world.Queued && str.Status != world.Building || !chunk.InQueue(str.Pos) {
+			err = errors.New("Structure not in construction queue")
+			break
+		}
 
-			// Calculate money
-			user.MoneyRate += int64(chunk.Structures[index].Money)
+		// Blocks covered by the structure are freed
+		if err = world.DestructStructure(area, str); err != nil {
+			break
+		}
 
-			// Calculate population
-			user.PopulationCap += int64(chunk.Structures[index].PopulationCap)
+		chunk.Dequeue(str.Pos)
 
-			if chunk.Structures[index].Population > 0 {
-				chunk.PopulationRate += int64(chunk.Structures[index].Population)
-			}
+		// Full refund before construction started, half after
+		if str.Status == world.Queued {
+			user.Resources[world.Money] += int64(str.Cost)
+		} else {
+			user.Resources[world.Money] += int64(str.Cost) / 2
 		}
+	case Reorder:
+		err = chunk.MoveInQueue(str.Pos, payload.QueueIndex)
+	default:
+		err = errors.New("Unknown action")
+	}
+
+	return
+}
+
+// Apply an action to listed structures or all structures matching the filter
+func (mHandler MessageHandler) onBulkActionRequest(request comm.MessageWrapper) {
+	var payload BulkActionPayload
+
+	if err := json.Unmarshal(request.Data, &payload); err != nil {
+		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.InvalidRequest, err.Error())
+		return
+	}
+
+	switch payload.Action {
+	case Upgrade, Destruct, Restart:
+	default:
+		mHandler.sendError(request, comm.InvalidRequest, "Action not supported in bulk.")
+		return
 	}
 
-	// Handle world error
+	mHandler.playerDB.Lock(request.Username)
+	defer mHandler.playerDB.Unlock(request.Username)
+
+	user, err := mHandler.playerDB.Get(request.Username)
 	if err != nil {
-		log.Println(err)
+		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.PlayerNotFound, err.Error())
 		return
 	}
+	user.Update(mHandler.clock.Now().Unix())
 
-	// Check chunk resource status(such as human not enough)
-	human_needed := 0
-	for _, b := range chunk.Structures {
-		human_needed += -b.Population
+	// Chunks of listed structures, or chunks in the filter
+	chunks := payload.Filter.Chunks
+	if len(payload.Structures) > 0 {
+		chunks = nil
+		for _, str := range payload.Structures {
+			chunks = append(chunks, str.Chunk)
+		}
+	} else if len(chunks) == 0 {
+		chunks = user.Territory
 	}
 
-	// TODO: Change building status when human not enough
-	//log.Println(human_needed, chunk.Population)
+	var keys []string
+	for _, pos := range chunks {
+		keys = append(keys, pos.String())
+	}
+
+	mHandler.worldDB.LockAll(keys)
+	defer mHandler.worldDB.UnlockAll(keys)
+
+	area := make(world.Area)
+	for _, key := range keys {
+		chunk, err := mHandler.worldDB.Get(key)
+		if err != nil {
+			continue
+		}
+
+		area[chunk.Pos] = &chunk
+	}
+
+	targets := payload.Structures
+	if len(targets) == 0 {
+		targets = payload.Filter.match(area, request.Username)
+	}
+
+	results := []ActionResult{}
+	for _, target := range targets {
+		result := ActionResult{Chunk: target.Chunk, Pos: target.Pos, ID: target.ID}
+
+		code, err := func() (comm.ErrorCode, error) {
+			chunk, ok := area[target.Chunk]
+			if !ok {
+				return comm.StructureNotFound, errors.New("Chunk not found.")
+			}
+
+			if chunk.Owner != request.Username {
+				return comm.PermissionDenied, errors.New("User do not own the chunk.")
+			}
+
+			index, err := world.GetStructure(*chunk, target)
+			if err != nil {
+				return comm.StructureNotFound, err
+			}
+
+			return mHandler.structureAction(&user, area, chunk, index, BuildingPayload{Action: payload.Action, Structure: target})
+		}()
+
+		if err != nil {
+			result.Code = code
+			result.Message = err.Error()
+		}
+
+		results = append(results, result)
+	}
+
+	log.Printf("[INFO] %s request bulk %s on %d structures", request.Username, string(payload.Action), len(results))
+
+	// Structures around may get or lose bonus
+	refreshBonuses(mHandler.GameDB, &user, request.Username, area)
 
-	// Write data into database if no world error happened
 	mHandler.playerDB.Put(request.Username, user)
-	mHandler.worldDB.Put(chunk.Key(), chunk)
+	mHandler.worldDB.PutAll(area.Chunks())
+
+	// Response keeps request ID of the request, no additional ack needed
+	b, err := json.Marshal(BulkActionResultPayload{
+		Payload: comm.Payload{Msg_type: comm.BulkActionResponse, Request_id: payload.Request_id},
+		Action:  payload.Action,
+		Results: results,
+	})
+	if err != nil {
+		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.ServerError, err.Error())
+		return
+	}
+
+	msg := request
+	msg.SendTo = comm.SendToClient
+	msg.Data = b
+
+	mHandler.mbus.Write("ws", msg)
 }
 
-// TODO: deal with long-distance move & boundary check
-// TODO: move delay
+// Start moving troops from an owned chunk, troops arrive after passing through the path
 func (mHandler MessageHandler) onOccupyRequest(request comm.MessageWrapper) {
 	var payload struct {
 		comm.Payload
@@ -381,6 +706,7 @@ func (mHandler MessageHandler) onOccupyRequest(request comm.MessageWrapper) {
 
 	if err := json.Unmarshal(request.Data, &payload); err != nil {
 		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.InvalidRequest, err.Error())
 		return
 	}
 
@@ -388,69 +714,66 @@ func (mHandler MessageHandler) onOccupyRequest(request comm.MessageWrapper) {
 
 	username := request.Username
 
-	// chunk operation
-	mHandler.playerDB.Lock(username)
-	defer mHandler.playerDB.Unlock(username)
+	if code, err := mHandler.validateMoveRequest(payload.From, payload.To, payload.Amount); err != nil {
+		log.Println("[INFO]", err)
+		mHandler.sendError(request, code, err.Error())
+		return
+	}
 
+	// chunk operation
 	mHandler.worldDB.Lock(payload.From.String())
 	defer mHandler.worldDB.Unlock(payload.From.String())
 
-	mHandler.worldDB.Lock(payload.To.String())
-	defer mHandler.worldDB.Unlock(payload.To.String())
-
 	chunk_from, err := mHandler.worldDB.Get(payload.From.String())
 	if err != nil {
 		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.ServerError, err.Error())
 		return
 	}
 
-	chunk_to, err := mHandler.worldDB.Get(payload.To.String())
-	if err != nil {
-		log.Println("[ERROR]", err)
+	if code, err := validateMoveSource(username, chunk_from, payload.Amount); err != nil {
+		log.Println("[INFO]", err)
+		mHandler.sendError(request, code, err.Error())
 		return
 	}
 
-	// check if player exist
-	player_data, err := mHandler.playerDB.Get(username)
+	path, duration, err := world.FindPath(payload.From, payload.To, mHandler.moveCost)
 	if err != nil {
-		log.Println("[ERROR]", err)
+		log.Println("[INFO]", err)
+		mHandler.sendError(request, comm.InvalidOperation, err.Error())
 		return
 	}
 
-	if chunk_from.Owner != username {
-		log.Println("[ERROR]", "User do not own the chunk")
-	}
-
-	if chunk_from.Population < payload.Amount {
-		log.Println("[ERROR]", "Source chunk population not enough")
+	current := mHandler.clock.Now()
+	mv := world.Movement{
+		ID:         fmt.Sprintf("%s@%d", username, current.UnixNano()),
+		Owner:      username,
+		From:       payload.From,
+		To:         payload.To,
+		Path:       path,
+		Amount:     payload.Amount,
+		StartTime:  current.Unix(),
+		ArriveTime: current.Unix() + duration,
 	}
 
-	if chunk_to.Owner != "" {
-		// TODO: Fighting
-	}
-
-	// Check finished, move minions
-	chunk_from.Population -= payload.Amount
-	chunk_to.Population += payload.Amount
-	chunk_to.Owner = username
+	// Troops leave the chunk
+	chunk_from.Troops -= payload.Amount
 
 	if err := mHandler.worldDB.Put(chunk_from.Key(), chunk_from); err != nil {
 		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.ServerError, err.Error())
 		return
 	}
 
-	if err := mHandler.worldDB.Put(chunk_to.Key(), chunk_to); err != nil {
+	if err := mHandler.moveDB.Put(mv); err != nil {
 		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.ServerError, err.Error())
 		return
 	}
 
-	// player operation
-	player_data.Territory = append(player_data.Territory, payload.To)
+	mHandler.scheduleMovement(mv)
 
-	if err := mHandler.playerDB.Put(username, player_data); err != nil {
-		log.Println("[ERROR]", err)
-		return
-	}
+	mHandler.sendAck(request)
 }
 
 func (mHandler MessageHandler) onHomePointResponse(request comm.MessageWrapper) {
@@ -460,6 +783,7 @@ func (mHandler MessageHandler) onHomePointResponse(request comm.MessageWrapper)
 
 	if err := json.Unmarshal(request.Data, &payload); err != nil {
 		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.InvalidRequest, err.Error())
 		return
 	}
 
@@ -496,6 +820,7 @@ func (mHandler MessageHandler) onHomePointResponse(request comm.MessageWrapper)
 	chunk, err := mHandler.worldDB.Get(Pos.String())
 	if err != nil {
 		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.ServerError, err.Error())
 		return
 	}
 
@@ -506,7 +831,7 @@ func (mHandler MessageHandler) onHomePointResponse(request comm.MessageWrapper)
 		// Chunk is occupied
 		if err != nil {
 			// Username not found! Send HomePointRequest to client
-			b, err := json.Marshal(comm.Payload{comm.HomePointRequest})
+			b, err := json.Marshal(comm.Payload{Msg_type: comm.HomePointRequest})
 			if err != nil {
 				log.Println("[ERROR]", err)
 			}
@@ -516,6 +841,8 @@ func (mHandler MessageHandler) onHomePointResponse(request comm.MessageWrapper)
 			msg.Data = b
 
 			mHandler.mbus.Write("ws", msg)
+		} else {
+			mHandler.sendError(request, comm.ChunkOccupied, "Chunk is occupied.")
 		}
 		return
 	} else {
@@ -527,9 +854,13 @@ func (mHandler MessageHandler) onHomePointResponse(request comm.MessageWrapper)
 		// Set population provided by home chunk
 		player_data.PopulationCap = 100
 
-		// TODO: Set default money! this is for testing
-		player_data.Money = 100000
-		player_data.MoneyRate = 100
+		// Initial stocks & base production of resources
+		player_data.Resources = make(map[string]int64)
+		player_data.Rates = make(map[string]int64)
+		for name, resource := range world.ResourceMap {
+			player_data.Resources[name] = resource.Initial
+			player_data.Rates[name] = resource.Rate
+		}
 
 		player_data.Home = Pos
 
@@ -538,26 +869,69 @@ func (mHandler MessageHandler) onHomePointResponse(request comm.MessageWrapper)
 		defer mHandler.startPlayerDataUpdate(ClientInfo{request.Cid, username})
 	}
 
-	// Provide initial population
+	// Provide initial population & troops
 	player_data.Population = 10
 	chunk.Population = 10
 
+	// Troops of player is the sum of its chunks, replace troops of the chunk
+	player_data.Troops += 10 - chunk.Troops
+	chunk.Troops = 10
+
 	if err := mHandler.worldDB.Put(chunk.Key(), chunk); err != nil {
 		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.ServerError, err.Error())
 		return
 	}
 
 	// player operation
-	player_data.Territory = append(player_data.Territory, Pos)
-	player_data.UpdateTime = time.Now().Unix()
+	player_data.AddTerritory(Pos)
+	player_data.UpdateTime = mHandler.clock.Now().Unix()
 
 	if err := mHandler.playerDB.Put(username, player_data); err != nil {
 		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.ServerError, err.Error())
 		return
 	}
+
+	mHandler.sendAck(request)
+}
+
+func (mHandler MessageHandler) onStructureCatalogRequest(request comm.MessageWrapper) {
+	var payload comm.Payload
+
+	if err := json.Unmarshal(request.Data, &payload); err != nil {
+		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.InvalidRequest, err.Error())
+		return
+	}
+
+	structures := []world.Structure{}
+	for _, str := range world.StructMap {
+		structures = append(structures, str)
+	}
+
+	sort.Slice(structures, func(i, j int) bool { return structures[i].ID < structures[j].ID })
+
+	// Response keeps request ID of the request, no additional ack needed
+	payload.Msg_type = comm.StructureCatalogResponse
+
+	b, err := json.Marshal(StructureCatalogPayload{payload, structures})
+	if err != nil {
+		log.Println("[ERROR]", err)
+		mHandler.sendError(request, comm.ServerError, err.Error())
+		return
+	}
+
+	msg := request
+	msg.SendTo = comm.SendToClient
+	msg.Data = b
+
+	mHandler.mbus.Write("ws", msg)
 }
 
 func (mHandler MessageHandler) onBroadcastMessage(request comm.MessageWrapper) {
 	request.SendTo = comm.Broadcast
 	mHandler.mbus.Write("ws", request)
+
+	mHandler.sendAck(request)
 }
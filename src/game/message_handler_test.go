@@ -0,0 +1,95 @@
+package game
+
+import (
+	"comm"
+	"encoding/json"
+	"game/player"
+	"game/world"
+	"testing"
+	"time"
+	"util"
+)
+
+func TestStructureActionRejectsNoop(t *testing.T) {
+	clock := util.NewManualClock(time.Unix(1000000, 0))
+	mHandler := newTestHandler(t, newTestDB(t, clock))
+
+	setStructMap(t, map[int]world.Structure{1: {Power: 10, BuildTime: 30}})
+
+	pos := util.Point{X: 0, Y: 0}
+	chunk := world.NewChunk(pos, clock.Now().Unix())
+	chunk.Owner = "alice"
+
+	destructing := runningStructure(1, pos, util.Point{X: 1, Y: 0})
+	destructing.Status = world.Destructing
+	chunk.Structures = []world.Structure{runningStructure(1, pos, util.Point{X: 0, Y: 0}), destructing}
+
+	user := player.Player{Resources: map[string]int64{world.Money: 1000}}
+	area := world.Area{pos: chunk}
+
+	cases := []struct {
+		action SAction
+		index  int
+	}{
+		{Restart, 0},
+		{Destruct, 1},
+	}
+
+	for _, c := range cases {
+		code, err := mHandler.structureAction(&user, area, chunk, c.index, BuildingPayload{Action: c.action, Structure: chunk.Structures[c.index]})
+		if err == nil || code != comm.InvalidOperation {
+			t.Errorf("%s on %v structure: code %v, error %v, want InvalidOperation", c.action, chunk.Structures[c.index].Status, code, err)
+		}
+	}
+}
+
+func TestRepairNeedsAllCoveredChunks(t *testing.T) {
+	clock := util.NewManualClock(time.Unix(1000000, 0))
+	db := newTestDB(t, clock)
+	mHandler := newTestHandler(t, db)
+
+	setStructMap(t, map[int]world.Structure{1: {Size: util.Size{W: 2, H: 1}, Cost: 100, BuildTime: 30}})
+
+	home, next := util.Point{X: 0, Y: 0}, util.Point{X: 1, Y: 0}
+	chunk := world.NewChunk(home, clock.Now().Unix())
+	chunk.Owner = "alice"
+	other := world.NewChunk(next, clock.Now().Unix())
+	other.Owner = "bob"
+
+	// Destroyed structure extends into chunk of other player
+	str := runningStructure(1, home, util.Point{X: int(world.ChunkSize.W) - 1, Y: 0})
+	str.Status = world.Destroyed
+	chunk.Structures = []world.Structure{str}
+	chunk.Blocks[str.Pos.X][0].Empty = false
+	other.Blocks[0][0].Empty = false
+
+	if err := db.worldDB.PutAll([]world.Chunk{*chunk, *other}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.playerDB.Put("alice", player.Player{Resources: map[string]int64{world.Money: 1000}, UpdateTime: clock.Now().Unix()}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(BuildingPayload{comm.Payload{Msg_type: comm.BuildRequest}, Repair, str, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mHandler.onBuildRequest(comm.MessageWrapper{Username: "alice", Data: b})
+
+	updated, err := db.worldDB.Get(chunk.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Structures[0].Status != world.Destroyed {
+		t.Errorf("status %v, want structure covering other's chunk kept destroyed", updated.Structures[0].Status)
+	}
+
+	alice, err := db.playerDB.Get("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.Resources[world.Money] != 1000 {
+		t.Errorf("money %d, want 1000", alice.Resources[world.Money])
+	}
+}
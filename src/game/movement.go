@@ -0,0 +1,281 @@
+package game
+
+import (
+	"comm"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"game/player"
+	"game/world"
+	"log"
+	"sort"
+	"util"
+)
+
+// This file is used to resolve troop movements
+
+// Seconds for troops to pass through the chunk, -1 if impassable
+func (mHandler MessageHandler) moveCost(pos util.Point) int64 {
+	mHandler.minimapLock.RLock()
+	defer mHandler.minimapLock.RUnlock()
+
+	terrain := mHandler.minimap.Terrain[pos.X+25][pos.Y+25]
+	if !terrain.Passable() {
+		return -1
+	}
+
+	return terrain.MoveTime()
+}
+
+// Check the movement request before reading chunk data
+func (mHandler MessageHandler) validateMoveRequest(from, to util.Point, amount int64) (code comm.ErrorCode, err error) {
+	switch {
+	case amount <= 0:
+		return comm.InvalidRequest, errors.New("Amount of troops must be positive.")
+	case !world.InWorld(from) || !world.InWorld(to):
+		return comm.InvalidRequest, errors.New("Chunk out of world.")
+	case from == to:
+		return comm.InvalidRequest, errors.New("Source and target chunk are the same.")
+	case mHandler.moveCost(to) < 0:
+		return comm.InvalidOperation, errors.New("Target chunk is impassable.")
+	}
+
+	return
+}
+
+// Check if the player can move troops out of the chunk
+func validateMoveSource(username string, chunk_from world.Chunk, amount int64) (code comm.ErrorCode, err error) {
+	switch {
+	case chunk_from.Owner != username:
+		return comm.PermissionDenied, errors.New("User do not own the chunk.")
+	case chunk_from.Troops < amount:
+		return comm.NotEnoughTroops, errors.New("Source chunk troops not enough.")
+	}
+
+	return
+}
+
+// Resolve the movement when troops arrive
+func (mHandler MessageHandler) scheduleMovement(mv world.Movement) {
+	if err := mHandler.scheduler.Schedule(Event{Type: MovementEvent, Time: mv.ArriveTime, Username: mv.Owner, Key: mv.ID}); err != nil {
+		log.Println("[ERROR]", err)
+	}
+}
+
+// Destroy structures of the defender extending into the lost chunk from
+// chunks nearby, caller should hold the locks of these chunks
+func (mHandler MessageHandler) destroyCovering(defender_data *player.Player, defender string, lost util.Point) {
+	current := mHandler.clock.Now().Unix()
+
+	for _, pos := range world.AnchorChunks(lost) {
+		if pos == lost {
+			continue
+		}
+
+		chunk, err := mHandler.worldDB.Get(pos.String())
+		if err != nil || chunk.Owner != defender {
+			continue
+		}
+
+		if DestroyCovering(defender_data, &chunk, lost, current) {
+			if err := mHandler.worldDB.Put(chunk.Key(), chunk); err != nil {
+				log.Println("[ERROR]", err)
+			}
+
+			// Structures nearby may lose bonus from destroyed ones
+			ScheduleBonusUpdates(mHandler.GameDB, nil, chunk.Structures)
+		}
+	}
+}
+
+// Remove structures on the captured chunk which extend into chunks nearby,
+// caller should hold the locks of the chunks returned by ReachChunks
+func (mHandler MessageHandler) removeSpanning(captured *world.Chunk) {
+	area := world.Area{captured.Pos: captured}
+	for _, pos := range world.ReachChunks(captured.Pos) {
+		if pos == captured.Pos {
+			continue
+		}
+
+		chunk, err := mHandler.worldDB.Get(pos.String())
+		if err != nil {
+			continue
+		}
+
+		area[pos] = &chunk
+	}
+
+	if err := RemoveSpanning(area, captured.Pos); err != nil {
+		log.Println("[ERROR]", err)
+		return
+	}
+
+	for pos, chunk := range area {
+		if pos == captured.Pos {
+			continue
+		}
+
+		if err := mHandler.worldDB.Put(chunk.Key(), *chunk); err != nil {
+			log.Println("[ERROR]", err)
+		}
+	}
+}
+
+// Troops arrive at target chunk, fight if the chunk is owned by other player
+func (mHandler MessageHandler) arrive(mv world.Movement) {
+	username := mv.Owner
+
+	// Peek owner of target chunk, players are locked in order to prevent
+	// dead lock when players attack each other
+	defender := func() string {
+		chunk, err := mHandler.worldDB.Get(mv.To.String())
+		if err != nil || chunk.Owner == username {
+			return ""
+		}
+		return chunk.Owner
+	}()
+
+	players := []string{username}
+	if defender != "" {
+		players = append(players, defender)
+		sort.Strings(players)
+	}
+
+	for _, name := range players {
+		mHandler.playerDB.Lock(name)
+		defer mHandler.playerDB.Unlock(name)
+	}
+
+	// Structures placed on chunks nearby may extend into the target chunk,
+	// and structures on the target chunk may extend into chunks nearby
+	var keys []string
+	for _, pos := range append(world.AnchorChunks(mv.To), world.ReachChunks(mv.To)...) {
+		keys = append(keys, pos.String())
+	}
+
+	mHandler.worldDB.LockAll(keys)
+	defer mHandler.worldDB.UnlockAll(keys)
+
+	// Movement has been resolved
+	if _, err := mHandler.moveDB.Get(mv.ID); err != nil {
+		return
+	}
+
+	chunk_to, err := mHandler.worldDB.Get(mv.To.String())
+	if err != nil {
+		log.Println("[ERROR]", err)
+		return
+	}
+
+	player_data, err := mHandler.playerDB.Get(username)
+	if err != nil {
+		log.Println("[ERROR]", err)
+		return
+	}
+
+	// Owner changed before chunk locked, try again after unlocked
+	if chunk_to.Owner != username && chunk_to.Owner != defender {
+		defer mHandler.scheduleMovement(mv)
+		return
+	}
+
+	captured := chunk_to.Owner != username
+
+	var survivors int64
+
+	if defender != "" {
+		defender_data, err := mHandler.playerDB.Get(defender)
+		if err != nil {
+			log.Println("[ERROR]", err)
+			return
+		}
+
+		// Resources produced before structures destroyed change the rates
+		defender_data.Update(mHandler.clock.Now().Unix())
+
+		result := Attack(&player_data, &defender_data, &chunk_to, mv.Amount, mHandler.clock.Now().Unix())
+		result.Attacker = username
+		result.Defender = defender
+
+		log.Printf("[INFO] %s attacked %s at (%s), remain %v : %v", username, defender, mv.To.String(), result.AttackerRemain, result.DefenderRemain)
+
+		if result.AttackerWin {
+			chunk_to.Owner = username
+			ScheduleBonusUpdates(mHandler.GameDB, nil, chunk_to.Structures)
+			mHandler.destroyCovering(&defender_data, defender, mv.To)
+			mHandler.removeSpanning(&chunk_to)
+		} else {
+			captured = false
+			survivors = result.AttackerRemain
+		}
+
+		if err := mHandler.playerDB.Put(defender, defender_data); err != nil {
+			log.Println("[ERROR]", err)
+			return
+		}
+
+		defer mHandler.sendBattleResult(result)
+	} else {
+		chunk_to.Troops += mv.Amount
+		chunk_to.Owner = username
+	}
+
+	if err := mHandler.worldDB.Put(chunk_to.Key(), chunk_to); err != nil {
+		log.Println("[ERROR]", err)
+		return
+	}
+
+	// player operation
+	if captured {
+		player_data.AddTerritory(mv.To)
+		mHandler.owner_changed <- chunk_to.Key()
+	}
+
+	if err := mHandler.playerDB.Put(username, player_data); err != nil {
+		log.Println("[ERROR]", err)
+		return
+	}
+
+	if err := mHandler.moveDB.Delete(mv); err != nil {
+		log.Println("[ERROR]", err)
+		return
+	}
+
+	// Survivors go back the same way
+	if survivors > 0 {
+		current := mHandler.clock.Now()
+		back := world.Movement{
+			ID:         fmt.Sprintf("%s@%d", username, current.UnixNano()),
+			Owner:      username,
+			From:       mv.To,
+			To:         mv.From,
+			Amount:     survivors,
+			StartTime:  current.Unix(),
+			ArriveTime: current.Unix() + mv.ArriveTime - mv.StartTime,
+		}
+
+		for i := len(mv.Path) - 1; i >= 0; i-- {
+			back.Path = append(back.Path, mv.Path[i])
+		}
+
+		if err := mHandler.moveDB.Put(back); err != nil {
+			log.Println("[ERROR]", err)
+			return
+		}
+
+		mHandler.scheduleMovement(back)
+	}
+}
+
+// Send battle result to both attacker and defender
+func (mHandler MessageHandler) sendBattleResult(result BattleResult) {
+	b, err := json.Marshal(BattleReportPayload{comm.Payload{Msg_type: comm.BattleReport}, result})
+	if err != nil {
+		log.Println("[ERROR]", err)
+		return
+	}
+
+	for _, username := range []string{result.Attacker, result.Defender} {
+		mHandler.mbus.Write("ws", comm.MessageWrapper{Username: username, SendTo: comm.SendToUser, Data: b})
+	}
+}
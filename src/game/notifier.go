@@ -63,6 +63,13 @@ func (notifier Notifier) start() {
 		}
 	}()
 
+	// movement update checking
+	go func() {
+		for mv := range notifier.moveDB.Updated {
+			notifier.movementUpdate(mv)
+		}
+	}()
+
 	// chunk owner change checking
 	go func() {
 		for key := range notifier.owner_changed {
@@ -142,7 +149,7 @@ func (notifier Notifier) mapDataUpdate(position util.Point) {
 
 		// send data to client
 		payload := comm.Payload{Msg_type: comm.MapDataResponse}
-		map_data := MapDataPayload{payload, chunks}
+		map_data := MapDataPayload{payload, chunks, movementsIn(notifier.GameDB, poss)}
 
 		b, err := json.Marshal(map_data)
 		if err != nil {
@@ -156,6 +163,80 @@ func (notifier Notifier) mapDataUpdate(position util.Point) {
 	}
 }
 
+func (notifier Notifier) movementUpdate(mv world.Movement) {
+	// read which clients are watching the path of movement
+	watching := make(map[ClientInfo]bool)
+
+	notifier.chunkLock.RLock()
+	for _, pos := range mv.Path {
+		for _, info := range notifier.chunk2Clients[pos] {
+			watching[info] = true
+		}
+	}
+	notifier.chunkLock.RUnlock()
+
+	// update the map of these clients
+	for info := range watching {
+		chunks := []world.Chunk{}
+
+		notifier.clientLock.RLock()
+		poss, ok := notifier.client2Chunks[info]
+		if !ok {
+			notifier.clientLock.RUnlock()
+			continue
+		}
+		notifier.clientLock.RUnlock()
+
+		for _, pos := range poss {
+			chunk, err := notifier.worldDB.Get(pos.String())
+			if err != nil {
+				log.Println("[WARNING]", err)
+				continue
+			}
+
+			chunks = append(chunks, chunk)
+		}
+
+		payload := comm.Payload{Msg_type: comm.MapDataResponse}
+		map_data := MapDataPayload{payload, chunks, movementsIn(notifier.GameDB, poss)}
+
+		b, err := json.Marshal(map_data)
+		if err != nil {
+			log.Println("[WARNING]", err)
+			continue
+		}
+
+		msg := comm.MessageWrapper{info.cid, info.username, comm.SendToClient, b}
+
+		notifier.mbus.Write("ws", msg)
+	}
+}
+
+// Load movements passing through any of the chunks
+func movementsIn(db GameDB, poss []util.Point) []world.Movement {
+	res := []world.Movement{}
+
+	movements, err := db.moveDB.List()
+	if err != nil {
+		log.Println("[WARNING]", err)
+		return res
+	}
+
+	for _, mv := range movements {
+		for _, pos := range poss {
+			if mv.Passes(pos) {
+				res = append(res, mv)
+				break
+			}
+		}
+	}
+
+	return res
+}
+
+// Ticks between checking recovery of shed structures
+const recoverInterval = 10
+
 func playerDataUpdate(client_info ClientInfo, user_ch <-chan string, mbus *comm.MBusNode, db GameDB) {
 	username := client_info.username
 
@@ -166,7 +247,9 @@ func playerDataUpdate(client_info ClientInfo, user_ch <-chan string, mbus *comm.
 	}
 
 	// Update player's data
-	player_data.Update()
+	player_data.Update(db.clock.Now().Unix())
+
+	var ticks int
 
 	for m := range user_ch {
 		if m == "update" {
@@ -175,15 +258,20 @@ func playerDataUpdate(client_info ClientInfo, user_ch <-chan string, mbus *comm.
 				log.Println("[WARNING]", err)
 				continue
 			}
+		} else {
+			ticks++
 		}
 
-		current_status := player_data.GetStatus()
+		current_status := player_data.GetStatus(db.clock.Now().Unix())
 
-		if current_status.Money < 0 || current_status.Power > current_status.PowerMax {
-			// No enough money or power for player
-			HaltPlayer(db, username)
-		} else {
-			b, err := json.Marshal(PlayerDataPayload{comm.Payload{comm.PlayerDataResponse}, current_status})
+		// Shed load when resources run out or power changes with player data,
+		// restart shed structures when resources recover
+		if current_status.Shortage() || m == "update" || ticks%recoverInterval == 0 {
+			BalanceLoad(db, username)
+		}
+
+		if !current_status.Shortage() {
+			b, err := json.Marshal(PlayerDataPayload{comm.Payload{Msg_type: comm.PlayerDataResponse}, current_status})
 			if err != nil {
 				log.Println("[WARNING]", err)
 				continue
@@ -40,7 +40,21 @@ func (pdb PlayerDB) Get(key string) (value Player, err error) {
 		return
 	}
 
-	err = json.Unmarshal(v, &value)
+	if err = json.Unmarshal(v, &value); err != nil || value.Resources != nil {
+		return
+	}
+
+	// Convert data saved before resources were introduced
+	legacy := struct {
+		Money     int64
+		MoneyRate int64
+	}{}
+	if err = json.Unmarshal(v, &legacy); err != nil {
+		return
+	}
+
+	value.Resources = map[string]int64{"Money": legacy.Money}
+	value.Rates = map[string]int64{"Money": legacy.MoneyRate}
 	return
 }
 
@@ -1,7 +1,6 @@
 package player
 
 import (
-	"time"
 	"util"
 )
 
@@ -9,11 +8,13 @@ type Player struct {
 	Population    int64
 	PopulationCap int64
 
-	Money     int64
-	MoneyRate int64
+	Troops int64 // Troops on all chunks, including moving troops
 
-	Power    int64
-	PowerMax int64
+	Resources map[string]int64 // Stock of each resource
+	Rates     map[string]int64 // Net production per second of each resource
+
+	Power    int64 // Power consumed by all grids
+	PowerMax int64 // Power generated by all grids
 
 	Home      util.Point // spawn point
 	Territory []util.Point
@@ -26,19 +27,115 @@ func NewPlayer() *Player {
 	return &Player{Territory: []util.Point{}}
 }
 
+// Add chunk to player's territory, territory never contains duplicated chunk
+func (player *Player) AddTerritory(pos util.Point) {
+	for _, p := range player.Territory {
+		if p == pos {
+			return
+		}
+	}
+
+	player.Territory = append(player.Territory, pos)
+}
+
+// Remove chunk from player's territory
+func (player *Player) RemoveTerritory(pos util.Point) {
+	for i, p := range player.Territory {
+		if p == pos {
+			player.Territory = append(player.Territory[:i], player.Territory[i+1:]...)
+			return
+		}
+	}
+}
+
+// Power grids of the player, each grid is a group of territory chunks
+// connected by edges. Power only flows within a grid
+func (player Player) Grids() (grids [][]util.Point) {
+	owned := make(map[util.Point]bool, len(player.Territory))
+	for _, pos := range player.Territory {
+		owned[pos] = true
+	}
+
+	visited := make(map[util.Point]bool, len(player.Territory))
+	for _, start := range player.Territory {
+		if visited[start] {
+			continue
+		}
+
+		visited[start] = true
+		grid := []util.Point{start}
+		for i := 0; i < len(grid); i++ {
+			pos := grid[i]
+			for _, next := range []util.Point{pos.Up(), pos.Down(), pos.Left(), pos.Right()} {
+				if owned[next] && !visited[next] {
+					visited[next] = true
+					grid = append(grid, next)
+				}
+			}
+		}
+
+		grids = append(grids, grid)
+	}
+
+	return
+}
+
 // Update player's current data based on current time & previous update time
 // TODO: Burst Link
-func (player *Player) Update() {
-	current := time.Now().Unix()
-	player.Money += player.MoneyRate * (current - player.UpdateTime)
+func (player *Player) Update(current int64) {
+	if player.Resources == nil {
+		player.Resources = make(map[string]int64)
+	}
+
+	// Game time may be behind update time after restarted with another
+	// clock, resources stay until game time catches up
+	if current <= player.UpdateTime {
+		return
+	}
+
+	for name, rate := range player.Rates {
+		player.Resources[name] += rate * (current - player.UpdateTime)
+	}
 	player.UpdateTime = current
 	return
 }
 
 // Same as update, but don't modify original player object
-func (player Player) GetStatus() Player {
-	current := time.Now().Unix()
-	player.Money += player.MoneyRate * (current - player.UpdateTime)
+func (player Player) GetStatus(current int64) Player {
+	resources := make(map[string]int64, len(player.Resources))
+	for name, amount := range player.Resources {
+		resources[name] = amount
+	}
+	player.Resources = resources
+
+	if current <= player.UpdateTime {
+		return player
+	}
+
+	for name, rate := range player.Rates {
+		player.Resources[name] += rate * (current - player.UpdateTime)
+	}
 
 	return player
 }
+
+// Add production rates of resources, negative factor for removing
+func (player *Player) AddRates(rates map[string]int64, factor int64) {
+	if player.Rates == nil {
+		player.Rates = make(map[string]int64)
+	}
+	for name, rate := range rates {
+		player.Rates[name] += rate * factor
+	}
+}
+
+// Check if any resource stock is running out
+func (player Player) Shortage() bool {
+	for _, amount := range player.Resources {
+		if amount < 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,59 @@
+package player
+
+import (
+	"testing"
+	"time"
+	"util"
+)
+
+func TestUpdateProducesResources(t *testing.T) {
+	clock := util.NewManualClock(time.Unix(1000000, 0))
+
+	player := Player{
+		Resources:  map[string]int64{"Money": 1000, "Food": 50},
+		Rates:      map[string]int64{"Money": 100, "Food": -5},
+		UpdateTime: clock.Now().Unix(),
+	}
+
+	clock.Advance(10 * time.Second)
+	player.Update(clock.Now().Unix())
+
+	if player.Resources["Money"] != 2000 || player.Resources["Food"] != 0 {
+		t.Fatalf("resources %v after 10 seconds, want Money 2000 & Food 0", player.Resources)
+	}
+
+	if player.UpdateTime != clock.Now().Unix() {
+		t.Fatalf("update time %d, want %d", player.UpdateTime, clock.Now().Unix())
+	}
+
+	// Status is calculated without changing the player
+	clock.Advance(5 * time.Second)
+	if status := player.GetStatus(clock.Now().Unix()); status.Resources["Money"] != 2500 {
+		t.Fatalf("status %v, want Money 2500", status.Resources)
+	}
+	if player.Resources["Money"] != 2000 {
+		t.Fatalf("GetStatus changed resources to %v", player.Resources)
+	}
+}
+
+func TestUpdateNeverGoesBack(t *testing.T) {
+	clock := util.NewManualClock(time.Unix(1000000, 0))
+
+	// Updated by a clock running ahead before restart
+	player := Player{
+		Resources:  map[string]int64{"Money": 1000},
+		Rates:      map[string]int64{"Money": 100},
+		UpdateTime: clock.Now().Unix() + 60,
+	}
+
+	player.Update(clock.Now().Unix())
+	if player.Resources["Money"] != 1000 || player.UpdateTime != clock.Now().Unix()+60 {
+		t.Fatalf("resources %v at %d, want unchanged", player.Resources, player.UpdateTime)
+	}
+
+	clock.Advance(70 * time.Second)
+	player.Update(clock.Now().Unix())
+	if player.Resources["Money"] != 2000 {
+		t.Fatalf("resources %v, want Money 2000", player.Resources)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"comm"
 	"game/player"
 	"game/world"
+	"sort"
 	"util"
 )
 
@@ -11,11 +12,14 @@ import (
 type SAction string
 
 const (
-	Build    SAction = "Build"
-	Upgrade  SAction = "Upgrade"
-	Destruct SAction = "Destruct"
-	Repair   SAction = "Repair"
-	Restart  SAction = "Restart"
+	Build      SAction = "Build"
+	Upgrade    SAction = "Upgrade"
+	Destruct   SAction = "Destruct"
+	Repair     SAction = "Repair"
+	Restart    SAction = "Restart"
+	Prioritize SAction = "Prioritize" // Set priority of the structure for load shedding
+	Cancel     SAction = "Cancel"     // Cancel structure in construction queue
+	Reorder    SAction = "Reorder"    // Move structure to another position of construction queue
 )
 
 type PlayerDataPayload struct {
@@ -25,7 +29,8 @@ type PlayerDataPayload struct {
 
 type MapDataPayload struct {
 	comm.Payload
-	Chunks []world.Chunk
+	Chunks    []world.Chunk
+	Movements []world.Movement // Troops passing through the chunks
 }
 
 type MinimapData struct {
@@ -39,8 +44,114 @@ type MinimapDataPayload struct {
 	MinimapData
 }
 
+// Result of a battle, sent to both attacker and defender
+type BattleResult struct {
+	Attacker string
+	Defender string
+	Chunk    util.Point
+
+	AttackerTroop  int64
+	DefenderTroop  int64 // Troops and defense of military structures
+	AttackerRemain int64
+	DefenderRemain int64
+
+	AttackerWin bool
+}
+
+type BattleReportPayload struct {
+	comm.Payload
+	BattleResult
+}
+
+// Definitions of all structures, sorted by ID
+type StructureCatalogPayload struct {
+	comm.Payload
+	Structures []world.Structure
+}
+
 type BuildingPayload struct {
 	comm.Payload
-	Action    SAction
-	Structure world.Structure
+	Action     SAction
+	Structure  world.Structure
+	QueueIndex int `json:",omitempty"` // New index in construction queue for Reorder
+}
+
+// Filter of structures for bulk action, empty fields match all
+type StructureFilter struct {
+	Chunks []util.Point // Chunks to search, all territory if empty
+	IDs    []int
+	Status []world.SStatus
+}
+
+// Structures in the owned chunks of the area matching the filter, sorted by
+// chunk and position
+func (filter StructureFilter) match(area world.Area, username string) (res []world.Structure) {
+	for _, chunk := range area {
+		if chunk.Owner != username {
+			continue
+		}
+
+		for _, str := range chunk.Structures {
+			if len(filter.IDs) > 0 && !containsID(filter.IDs, str.ID) {
+				continue
+			}
+
+			if len(filter.Status) > 0 && !containsStatus(filter.Status, str.Status) {
+				continue
+			}
+
+			res = append(res, str)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Chunk != res[j].Chunk {
+			return res[i].Chunk.String() < res[j].Chunk.String()
+		}
+		return res[i].Pos.String() < res[j].Pos.String()
+	})
+
+	return
+}
+
+func containsID(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsStatus(status []world.SStatus, s world.SStatus) bool {
+	for _, st := range status {
+		if st == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply action to listed structures, or structures matching the filter if no
+// structure is listed. Only Upgrade, Destruct & Restart are supported
+type BulkActionPayload struct {
+	comm.Payload
+	Action     SAction
+	Structures []world.Structure // Structures given by chunk, position & ID
+	Filter     StructureFilter
+}
+
+// Result of action on a single structure, Code is empty on success
+type ActionResult struct {
+	Chunk   util.Point
+	Pos     util.Point
+	ID      int
+	Code    comm.ErrorCode `json:",omitempty"`
+	Message string         `json:",omitempty"`
+}
+
+type BulkActionResultPayload struct {
+	comm.Payload
+	Action  SAction
+	Results []ActionResult
 }
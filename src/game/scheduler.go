@@ -0,0 +1,199 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"log"
+	"sync/atomic"
+	"time"
+	"util"
+)
+
+// This file is used to run timed game events
+
+// Type of timed event
+type EventType string
+
+const (
+	ChunkUpdateEvent EventType = "ChunkUpdate" // Structures on the chunk finish constructing or destructing
+	MovementEvent    EventType = "Movement"    // Troops arrive at target chunk
+	PopulationEvent  EventType = "Population"  // Population grows & troops are trained
+	BonusUpdateEvent EventType = "BonusUpdate" // Structures nearby started or stopped running
+)
+
+// Timed event, kept in DB until handled
+type Event struct {
+	ID       string
+	Type     EventType
+	Time     int64  // Unix time
+	Username string `json:",omitempty"`
+	Key      string `json:",omitempty"` // Chunk key or movement ID
+}
+
+// DB key of the event, events are sorted by time in DB
+func (ev Event) dbKey() []byte {
+	return []byte(fmt.Sprintf("%020d/%s", ev.Time, ev.ID))
+}
+
+type EventHandler func(Event)
+
+// Scheduler runs events in time order. Pending events are persisted in
+// LevelDB, so they are resumed exactly after restart
+type Scheduler struct {
+	db       *leveldb.DB
+	clock    util.Clock
+	handlers map[EventType]EventHandler
+	wake     chan struct{}
+	seq      *uint64
+}
+
+func NewScheduler(path string, clock util.Clock) (scheduler *Scheduler, err error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return
+	}
+
+	scheduler = &Scheduler{db, clock, make(map[EventType]EventHandler), make(chan struct{}, 1), new(uint64)}
+	return
+}
+
+func (scheduler Scheduler) Close() error {
+	return scheduler.db.Close()
+}
+
+// Set handler of the event type, should be called before scheduler started
+func (scheduler Scheduler) Handle(event_type EventType, handler EventHandler) {
+	scheduler.handlers[event_type] = handler
+}
+
+// Add event to be handled at its time, event ID is generated
+func (scheduler Scheduler) Schedule(ev Event) (err error) {
+	ev.ID = fmt.Sprintf("%d-%d", scheduler.clock.Now().UnixNano(), atomic.AddUint64(scheduler.seq, 1))
+
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+
+	if err = scheduler.db.Put(ev.dbKey(), b, nil); err != nil {
+		return
+	}
+
+	// Wake up scheduler in case the event is earlier than all others
+	select {
+	case scheduler.wake <- struct{}{}:
+	default:
+	}
+
+	return
+}
+
+// Add event to be handled after seconds
+func (scheduler Scheduler) After(seconds int64, ev Event) error {
+	ev.Time = scheduler.clock.Now().Unix() + seconds
+	return scheduler.Schedule(ev)
+}
+
+// Pending events of the type in time order, all events if type is empty
+func (scheduler Scheduler) Pending(event_type EventType) (events []Event, err error) {
+	iter := scheduler.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		var ev Event
+		if err = json.Unmarshal(iter.Value(), &ev); err != nil {
+			return
+		}
+
+		if event_type == "" || ev.Type == event_type {
+			events = append(events, ev)
+		}
+	}
+
+	err = iter.Error()
+	return
+}
+
+// Keys of pending events grouped by event type
+func (scheduler Scheduler) PendingKeys() (keys map[EventType]map[string]bool, err error) {
+	events, err := scheduler.Pending("")
+	if err != nil {
+		return
+	}
+
+	keys = make(map[EventType]map[string]bool)
+	for _, ev := range events {
+		if keys[ev.Type] == nil {
+			keys[ev.Type] = make(map[string]bool)
+		}
+		keys[ev.Type][ev.Key] = true
+	}
+
+	return
+}
+
+// Earliest pending event
+func (scheduler Scheduler) next() (ev Event, ok bool) {
+	iter := scheduler.db.NewIterator(nil, nil)
+	defer iter.Release()
+
+	if !iter.First() {
+		return
+	}
+
+	if err := json.Unmarshal(iter.Value(), &ev); err != nil {
+		// Drop broken event, or it blocks all events after it
+		log.Println("[ERROR]", err)
+		scheduler.db.Delete(iter.Key(), nil)
+		return
+	}
+
+	return ev, true
+}
+
+// Handle all events due at current time, returns number of handled events.
+// Event is removed before handled, so handlers scheduling the next event
+// never run twice for the same event. Operations interrupted by shutdown are
+// rescheduled by the engine on start
+func (scheduler Scheduler) RunDue() (count int) {
+	for {
+		ev, ok := scheduler.next()
+		if !ok || ev.Time > scheduler.clock.Now().Unix() {
+			return
+		}
+
+		if err := scheduler.db.Delete(ev.dbKey(), nil); err != nil {
+			// Never run the event again and again
+			log.Println("[ERROR]", err)
+			return
+		}
+
+		if handler, ok := scheduler.handlers[ev.Type]; ok {
+			handler(ev)
+		} else {
+			log.Println("[WARNING] No handler for event", ev.Type)
+		}
+
+		count++
+	}
+}
+
+// Run events in background
+func (scheduler Scheduler) Start() {
+	go func() {
+		for {
+			scheduler.RunDue()
+
+			var timer <-chan time.Time
+			if ev, ok := scheduler.next(); ok {
+				timer = scheduler.clock.After(time.Unix(ev.Time, 0).Sub(scheduler.clock.Now()))
+			}
+
+			select {
+			case <-timer:
+			case <-scheduler.wake:
+			}
+		}
+	}()
+}
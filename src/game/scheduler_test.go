@@ -0,0 +1,131 @@
+package game
+
+import (
+	"testing"
+	"time"
+	"util"
+)
+
+func TestSchedulerRunsDueEventsInOrder(t *testing.T) {
+	clock := util.NewManualClock(time.Unix(1000000, 0))
+	scheduler, err := NewScheduler(t.TempDir(), clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scheduler.Close()
+
+	var handled []string
+	scheduler.Handle(ChunkUpdateEvent, func(ev Event) {
+		handled = append(handled, ev.Key)
+	})
+
+	scheduler.After(10, Event{Type: ChunkUpdateEvent, Key: "late"})
+	scheduler.After(5, Event{Type: ChunkUpdateEvent, Key: "early"})
+
+	if n := scheduler.RunDue(); n != 0 {
+		t.Fatalf("handled %d events before due", n)
+	}
+
+	clock.Advance(5 * time.Second)
+	if n := scheduler.RunDue(); n != 1 || handled[0] != "early" {
+		t.Fatalf("handled %v after 5 seconds, want [early]", handled)
+	}
+
+	clock.Advance(10 * time.Second)
+	if n := scheduler.RunDue(); n != 1 || handled[1] != "late" {
+		t.Fatalf("handled %v after 15 seconds, want [early late]", handled)
+	}
+
+	if events, _ := scheduler.Pending(""); len(events) != 0 {
+		t.Fatalf("%d events left after handled", len(events))
+	}
+}
+
+func TestSchedulerKeepsEventsAfterRestart(t *testing.T) {
+	clock := util.NewManualClock(time.Unix(1000000, 0))
+	dir := t.TempDir()
+
+	scheduler, err := NewScheduler(dir, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler.After(60, Event{Type: MovementEvent, Username: "alice", Key: "alice@1"})
+	scheduler.Close()
+
+	if scheduler, err = NewScheduler(dir, clock); err != nil {
+		t.Fatal(err)
+	}
+	defer scheduler.Close()
+
+	keys, err := scheduler.PendingKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keys[MovementEvent]["alice@1"] {
+		t.Fatalf("event lost after restart, pending %v", keys)
+	}
+}
+
+func TestSchedulerChainedEventNotDuplicated(t *testing.T) {
+	clock := util.NewManualClock(time.Unix(1000000, 0))
+	scheduler, err := NewScheduler(t.TempDir(), clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scheduler.Close()
+
+	// Handler schedules the next event like population updates
+	var runs int
+	scheduler.Handle(PopulationEvent, func(ev Event) {
+		runs++
+		if events, _ := scheduler.Pending(PopulationEvent); len(events) != 0 {
+			t.Errorf("event still pending while handled")
+		}
+		scheduler.After(2, Event{Type: PopulationEvent})
+	})
+	scheduler.After(2, Event{Type: PopulationEvent})
+
+	for i := 0; i < 5; i++ {
+		clock.Advance(2 * time.Second)
+		scheduler.RunDue()
+	}
+
+	if runs != 5 {
+		t.Errorf("handled %d times, want 5", runs)
+	}
+	if events, _ := scheduler.Pending(PopulationEvent); len(events) != 1 {
+		t.Errorf("%d population events pending, want 1", len(events))
+	}
+}
+
+func TestSchedulerStartWakesOnClock(t *testing.T) {
+	clock := util.NewManualClock(time.Unix(1000000, 0))
+	scheduler, err := NewScheduler(t.TempDir(), clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scheduler.Close()
+
+	done := make(chan Event, 1)
+	scheduler.Handle(ChunkUpdateEvent, func(ev Event) { done <- ev })
+	scheduler.Start()
+
+	scheduler.After(30, Event{Type: ChunkUpdateEvent, Key: "0,0"})
+
+	select {
+	case <-done:
+		t.Fatal("event handled before due")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(30 * time.Second)
+
+	select {
+	case ev := <-done:
+		if ev.Key != "0,0" {
+			t.Fatalf("handled %v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not handled after due")
+	}
+}
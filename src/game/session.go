@@ -0,0 +1,75 @@
+package game
+
+import (
+	"config"
+	"time"
+	"util"
+)
+
+// This file is used to keep viewport of client sessions, so that a client
+// reconnecting with session token keeps watching the same chunks
+
+// Viewport of a session, kept for a while after the client disconnected
+type sessionView struct {
+	username string
+	chunks   []util.Point
+	expires  int64 // Unix time, 0 while the client is connected
+}
+
+// Bind the client to the session, returns chunks watched before if the
+// session is resumed
+func (mHandler MessageHandler) openSession(client_info ClientInfo, session string, resumed bool) (chunks []util.Point) {
+	mHandler.sessionLock.Lock()
+	defer mHandler.sessionLock.Unlock()
+
+	// Remove sessions which can no longer be resumed
+	current := time.Now().Unix()
+	for id, view := range mHandler.sessions {
+		if view.expires != 0 && view.expires < current {
+			delete(mHandler.sessions, id)
+		}
+	}
+
+	view, ok := mHandler.sessions[session]
+	if !ok || view.username != client_info.username {
+		view = &sessionView{username: client_info.username}
+		mHandler.sessions[session] = view
+	} else if resumed {
+		chunks = view.chunks
+	}
+
+	view.expires = 0
+	mHandler.client2Session[client_info] = session
+
+	return
+}
+
+// Keep viewport of the client's session until it expires. Session resumed by
+// another client before this client is timed out is still in use
+func (mHandler MessageHandler) closeSession(client_info ClientInfo) {
+	mHandler.sessionLock.Lock()
+	defer mHandler.sessionLock.Unlock()
+
+	session := mHandler.client2Session[client_info]
+	delete(mHandler.client2Session, client_info)
+
+	for _, s := range mHandler.client2Session {
+		if s == session {
+			return
+		}
+	}
+
+	if view, ok := mHandler.sessions[session]; ok {
+		view.expires = time.Now().Unix() + config.SessionTTL
+	}
+}
+
+// Save chunks watched by the client to its session
+func (mHandler MessageHandler) saveViewport(client_info ClientInfo, chunks []util.Point) {
+	mHandler.sessionLock.Lock()
+	defer mHandler.sessionLock.Unlock()
+
+	if view, ok := mHandler.sessions[mHandler.client2Session[client_info]]; ok {
+		view.chunks = chunks
+	}
+}
@@ -0,0 +1,36 @@
+package game
+
+import (
+	"sync"
+	"testing"
+	"util"
+)
+
+func TestSessionKeptWhileResumedByAnotherClient(t *testing.T) {
+	mHandler := MessageHandler{}
+	mHandler.client2Session = make(map[ClientInfo]string)
+	mHandler.sessions = make(map[string]*sessionView)
+	mHandler.sessionLock = new(sync.Mutex)
+
+	stale := ClientInfo{1, "alice"}
+	resumed := ClientInfo{2, "alice"}
+	viewport := []util.Point{{X: 1, Y: 2}}
+
+	mHandler.openSession(stale, "s1", false)
+	mHandler.saveViewport(stale, viewport)
+
+	// Client reconnects before its half-open connection is timed out
+	if chunks := mHandler.openSession(resumed, "s1", true); len(chunks) != 1 || chunks[0] != viewport[0] {
+		t.Fatalf("resumed viewport %v, want %v", chunks, viewport)
+	}
+
+	mHandler.closeSession(stale)
+	if view := mHandler.sessions["s1"]; view == nil || view.expires != 0 {
+		t.Fatalf("session in use expired after stale client closed: %+v", view)
+	}
+
+	mHandler.closeSession(resumed)
+	if view := mHandler.sessions["s1"]; view == nil || view.expires == 0 {
+		t.Fatalf("session not expiring after last client closed: %+v", view)
+	}
+}
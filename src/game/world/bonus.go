@@ -0,0 +1,178 @@
+package world
+
+import (
+	"util"
+)
+
+// Bonus provided when a structure is close to terrain or other structures
+type Bonus struct {
+	Terrain   int // Terrain mask, applies once if any block around matches
+	Structure int // Structure ID, applies for each running structure around
+	Radius    int // Distance in blocks from the border of structure
+
+	Produce    map[string]int // Extra resources produced per second
+	Power      int
+	Population int
+}
+
+// Rectangle of the structure in world block coordinate
+func globalRect(str Structure) (from util.Point, to util.Point) {
+	from = util.Point{
+		X: str.Chunk.X*int(ChunkSize.W) + str.Pos.X,
+		Y: str.Chunk.Y*int(ChunkSize.H) + str.Pos.Y,
+	}
+	to = util.Point{X: from.X + int(str.Size.W) - 1, Y: from.Y + int(str.Size.H) - 1}
+	return
+}
+
+// Chunks with blocks within radius of any bonus of the structure
+func BonusChunks(str Structure) (res []util.Point) {
+	var radius int
+	for _, bonus := range str.Bonuses {
+		if bonus.Radius > radius {
+			radius = bonus.Radius
+		}
+	}
+
+	from, to := globalRect(str)
+	cfrom, _ := locate(util.Point{}, util.Point{X: from.X - radius, Y: from.Y - radius})
+	cto, _ := locate(util.Point{}, util.Point{X: to.X + radius, Y: to.Y + radius})
+
+	for x := cfrom.X; x <= cto.X; x++ {
+		for y := cfrom.Y; y <= cto.Y; y++ {
+			res = append(res, util.Point{X: x, Y: y})
+		}
+	}
+
+	return
+}
+
+// Chunks where structures getting bonus from the structure may be placed,
+// their bonuses change when the structure starts or stops running
+func AffectedChunks(str Structure) (res []util.Point) {
+	var radius, size int
+	var affects bool
+	for _, def := range StructMap {
+		for _, bonus := range def.Bonuses {
+			if bonus.Structure != str.ID {
+				continue
+			}
+
+			affects = true
+			if bonus.Radius > radius {
+				radius = bonus.Radius
+			}
+		}
+
+		if int(def.Size.W) > size {
+			size = int(def.Size.W)
+		}
+		if int(def.Size.H) > size {
+			size = int(def.Size.H)
+		}
+	}
+
+	// No structure gets bonus from it
+	if !affects {
+		return
+	}
+
+	// Structures are placed at their top left block, which may be far from
+	// the block within radius
+	from, to := globalRect(str)
+	cfrom, _ := locate(util.Point{}, util.Point{X: from.X - radius - size + 1, Y: from.Y - radius - size + 1})
+	cto, _ := locate(util.Point{}, util.Point{X: to.X + radius, Y: to.Y + radius})
+
+	for x := cfrom.X; x <= cto.X; x++ {
+		for y := cfrom.Y; y <= cto.Y; y++ {
+			res = append(res, util.Point{X: x, Y: y})
+		}
+	}
+
+	return
+}
+
+// Check if structure `other` is within radius of structure `str`
+func within(str Structure, other Structure, radius int) bool {
+	from, to := globalRect(str)
+	ofrom, oto := globalRect(other)
+
+	return ofrom.X <= to.X+radius && oto.X >= from.X-radius && ofrom.Y <= to.Y+radius && oto.Y >= from.Y-radius
+}
+
+// Check if any block within radius around the structure accepts the terrain mask
+func (area Area) terrainAround(str Structure, terrains int, radius int) bool {
+	pos, size := str.Pos, str.Size
+	r := uint(radius)
+	around := util.InSizeRange(util.Point{X: pos.X - radius, Y: pos.Y - radius}, util.Size{W: size.W + 2*r, H: size.H + 2*r})
+
+	for _, point := range around {
+		// Skip blocks under the structure
+		if point.X >= pos.X && point.X < pos.X+int(size.W) && point.Y >= pos.Y && point.Y < pos.Y+int(size.H) {
+			continue
+		}
+
+		// Blocks out of the area are skipped, the area should contain
+		// chunks given by BonusChunks
+		if block := area.block(str.Chunk, point); block != nil && block.Terrain.Accepts(terrains) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Total bonus of the structure from terrain & running structures in the
+// area, the area should contain chunks given by BonusChunks
+func (area Area) Bonus(str Structure) (produce map[string]int, power, population int) {
+	produce = make(map[string]int)
+
+	for _, bonus := range str.Bonuses {
+		var times int
+
+		if bonus.Terrain != 0 && area.terrainAround(str, bonus.Terrain, bonus.Radius) {
+			times++
+		}
+
+		if bonus.Structure != 0 {
+			for _, chunk := range area {
+				for _, other := range chunk.Structures {
+					if other.ID != bonus.Structure || other.Status != Running {
+						continue
+					}
+
+					// Skip structure itself
+					if other.Chunk == str.Chunk && other.Pos == str.Pos {
+						continue
+					}
+
+					if within(str, other, bonus.Radius) {
+						times++
+					}
+				}
+			}
+		}
+
+		for name, amount := range bonus.Produce {
+			produce[name] += amount * times
+		}
+		power += bonus.Power * times
+		population += bonus.Population * times
+	}
+
+	return
+}
+
+// Set properties to the level scaled values with bonus in the area
+func (area Area) ApplyBonus(str *Structure) {
+	str.SetLevel(str.Level)
+
+	produce, power, population := area.Bonus(*str)
+	for name, amount := range produce {
+		if amount != 0 {
+			str.Produce[name] += amount
+		}
+	}
+	str.Power += power
+	str.Population += population
+}
@@ -3,6 +3,7 @@ package world
 import (
 	"encoding/json"
 	"github.com/syndtr/goleveldb/leveldb"
+	"sort"
 	"sync"
 )
 
@@ -40,10 +41,48 @@ func (wdb WorldDB) Get(key string) (value Chunk, err error) {
 		return
 	}
 
-	err = json.Unmarshal(v, &value)
+	if err = json.Unmarshal(v, &value); err != nil {
+		return
+	}
+
+	err = convertLegacyMoney(v, &value)
 	return
 }
 
+// Convert structures saved before resources were introduced, their Money
+// field is + for produce, - for consume
+func convertLegacyMoney(data []byte, chunk *Chunk) error {
+	legacy := struct {
+		Structures []struct {
+			Money int
+		}
+	}{}
+
+	for i, str := range chunk.Structures {
+		if str.Produce != nil || str.Consume != nil {
+			continue
+		}
+
+		// Decode old fields only once, when a legacy structure is found
+		if legacy.Structures == nil {
+			if err := json.Unmarshal(data, &legacy); err != nil {
+				return err
+			}
+		}
+
+		money := legacy.Structures[i].Money
+		chunk.Structures[i].Produce = make(map[string]int)
+		chunk.Structures[i].Consume = make(map[string]int)
+		if money > 0 {
+			chunk.Structures[i].Produce[Money] = money
+		} else if money < 0 {
+			chunk.Structures[i].Consume[Money] = -money
+		}
+	}
+
+	return nil
+}
+
 func (wdb WorldDB) Put(key string, value Chunk) (err error) {
 	err = wdb.Load(key, value)
 	if err != nil {
@@ -54,6 +93,35 @@ func (wdb WorldDB) Put(key string, value Chunk) (err error) {
 	return
 }
 
+// Write chunks in a single batch, either all or none of them are written
+func (wdb WorldDB) PutAll(values []Chunk) (err error) {
+	batch := new(leveldb.Batch)
+
+	for _, value := range values {
+		if _, ok := wdb.mapLock[value.Key()]; !ok {
+			wdb.mapLock[value.Key()] = new(sync.Mutex)
+		}
+
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		batch.Put([]byte(value.Key()), b)
+	}
+
+	err = wdb.DB.Write(batch, nil)
+	if err != nil {
+		return
+	}
+
+	for _, value := range values {
+		wdb.Updated <- value.Key()
+	}
+
+	return
+}
+
 func (wdb WorldDB) Load(key string, value Chunk) (err error) {
 	if _, ok := wdb.mapLock[key]; !ok {
 		wdb.mapLock[key] = new(sync.Mutex)
@@ -89,3 +157,120 @@ func (wdb WorldDB) Unlock(key string) {
 		wdb.mapLock[key].Unlock()
 	}
 }
+
+// Lock multiple chunks in order to prevent dead lock, duplicated keys are ignored
+func (wdb WorldDB) LockAll(keys []string) {
+	for _, key := range uniqueKeys(keys) {
+		wdb.Lock(key)
+	}
+}
+
+func (wdb WorldDB) UnlockAll(keys []string) {
+	for _, key := range uniqueKeys(keys) {
+		wdb.Unlock(key)
+	}
+}
+
+func uniqueKeys(keys []string) (res []string) {
+	set := make(map[string]bool)
+
+	for _, key := range keys {
+		if !set[key] {
+			set[key] = true
+			res = append(res, key)
+		}
+	}
+
+	sort.Strings(res)
+	return
+}
+
+type MovementDB struct {
+	*leveldb.DB
+	closed *bool
+	lock   *sync.RWMutex // prevent sending to Updated after closed
+
+	Updated chan Movement // indicate which movement have been changed
+}
+
+func NewMovementDB(path string) (mdb *MovementDB, err error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return
+	}
+
+	mdb = &MovementDB{db, new(bool), new(sync.RWMutex), make(chan Movement, 256)}
+	return
+}
+
+func (mdb MovementDB) Close() error {
+	mdb.lock.Lock()
+	*mdb.closed = true
+	close(mdb.Updated)
+	mdb.lock.Unlock()
+
+	return mdb.DB.Close()
+}
+
+// Send changed movement to Updated unless the DB is closed
+func (mdb MovementDB) notify(mv Movement) {
+	mdb.lock.RLock()
+	defer mdb.lock.RUnlock()
+
+	if !*mdb.closed {
+		mdb.Updated <- mv
+	}
+}
+
+func (mdb MovementDB) Delete(mv Movement) (err error) {
+	err = mdb.DB.Delete([]byte(mv.ID), nil)
+	if err != nil {
+		return
+	}
+
+	mdb.notify(mv)
+	return
+}
+
+func (mdb MovementDB) Get(key string) (value Movement, err error) {
+	v, err := mdb.DB.Get([]byte(key), nil)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(v, &value)
+	return
+}
+
+func (mdb MovementDB) Put(value Movement) (err error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	err = mdb.DB.Put([]byte(value.ID), b, nil)
+	if err != nil {
+		return
+	}
+
+	mdb.notify(value)
+	return
+}
+
+// List all movements in progress
+func (mdb MovementDB) List() (res []Movement, err error) {
+	iter := mdb.NewIterator(nil, nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		var mv Movement
+		if err = json.Unmarshal(iter.Value(), &mv); err != nil {
+			return
+		}
+
+		res = append(res, mv)
+	}
+
+	err = iter.Error()
+	return
+}
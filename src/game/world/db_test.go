@@ -0,0 +1,71 @@
+package world
+
+import (
+	"path"
+	"testing"
+)
+
+func TestGetConvertsLegacyStructureMoney(t *testing.T) {
+	wdb, err := NewWorldDB(path.Join(t.TempDir(), "world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wdb.Close()
+
+	// Chunk saved before resources were introduced
+	legacy := `{"Owner":"alice","Structures":[{"ID":1,"Money":3},{"ID":2,"Money":-2},{"ID":3,"Money":0}]}`
+	if err := wdb.DB.Put([]byte("0,0"), []byte(legacy), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	chunk, err := wdb.Get("0,0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []map[string]int64{{Money: 3}, {Money: -2}, {}}
+	for i, str := range chunk.Structures {
+		if rates := str.Rates(); !sameRates(rates, want[i]) {
+			t.Errorf("structure %d rates = %v, want %v", str.ID, rates, want[i])
+		}
+	}
+}
+
+func TestGetKeepsResourceMaps(t *testing.T) {
+	wdb, err := NewWorldDB(path.Join(t.TempDir(), "world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wdb.Close()
+
+	chunk := Chunk{Owner: "alice", Structures: []Structure{
+		{ID: 1, Produce: map[string]int{"Food": 4}, Consume: map[string]int{Money: 1}},
+	}}
+	if err := wdb.Put("0,0", chunk); err != nil {
+		t.Fatal(err)
+	}
+	<-wdb.Updated
+
+	got, err := wdb.Get("0,0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.Structures[0].SameOutput(chunk.Structures[0]) {
+		t.Errorf("structure = %+v, want %+v", got.Structures[0], chunk.Structures[0])
+	}
+}
+
+func sameRates(a, b map[string]int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for name, rate := range a {
+		if b[name] != rate {
+			return false
+		}
+	}
+
+	return true
+}
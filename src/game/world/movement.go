@@ -0,0 +1,118 @@
+package world
+
+import (
+	"container/heap"
+	"errors"
+	"util"
+)
+
+// Troops moving from one chunk to another
+type Movement struct {
+	ID     string
+	Owner  string
+	From   util.Point
+	To     util.Point
+	Path   []util.Point // Chunks passed by, including From and To
+	Amount int64
+
+	StartTime  int64 // Unix time
+	ArriveTime int64 // Unix time
+}
+
+// Check if the movement passes through the chunk
+func (mv Movement) Passes(pos util.Point) bool {
+	for _, p := range mv.Path {
+		if p == pos {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check if the chunk is inside the world
+func InWorld(pos util.Point) bool {
+	halfW, halfH := int(WorldSize.W)/2, int(WorldSize.H)/2
+
+	return pos.X >= -halfW && pos.X < int(WorldSize.W)-halfW && pos.Y >= -halfH && pos.Y < int(WorldSize.H)-halfH
+}
+
+// Chunks next to the position inside the world
+func Neighbors(pos util.Point) (res []util.Point) {
+	for _, p := range []util.Point{pos.Up(), pos.Down(), pos.Left(), pos.Right()} {
+		if InWorld(p) {
+			res = append(res, p)
+		}
+	}
+
+	return
+}
+
+// Priority queue for path finding
+type pathNode struct {
+	pos  util.Point
+	cost int64
+}
+
+type pathQueue []pathNode
+
+func (q pathQueue) Len() int            { return len(q) }
+func (q pathQueue) Less(i, j int) bool  { return q[i].cost < q[j].cost }
+func (q pathQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pathQueue) Push(x interface{}) { *q = append(*q, x.(pathNode)) }
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	node := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return node
+}
+
+// Find the fastest path between chunks through adjacent chunks. `cost` returns
+// seconds to pass through a chunk, negative value means the chunk is impassable.
+func FindPath(from util.Point, to util.Point, cost func(util.Point) int64) (path []util.Point, duration int64, err error) {
+	if !InWorld(from) || !InWorld(to) {
+		err = errors.New("Chunk out of world")
+		return
+	}
+
+	dist := map[util.Point]int64{from: 0}
+	prev := make(map[util.Point]util.Point)
+
+	queue := &pathQueue{{from, 0}}
+	for queue.Len() > 0 {
+		node := heap.Pop(queue).(pathNode)
+		if node.pos == to {
+			break
+		}
+
+		if node.cost > dist[node.pos] {
+			continue
+		}
+
+		for _, next := range Neighbors(node.pos) {
+			c := cost(next)
+			if c < 0 {
+				continue
+			}
+
+			if d, ok := dist[next]; !ok || node.cost+c < d {
+				dist[next] = node.cost + c
+				prev[next] = node.pos
+				heap.Push(queue, pathNode{next, node.cost + c})
+			}
+		}
+	}
+
+	duration, ok := dist[to]
+	if !ok {
+		err = errors.New("No path to the chunk")
+		return
+	}
+
+	for p := to; p != from; p = prev[p] {
+		path = append([]util.Point{p}, path...)
+	}
+	path = append([]util.Point{from}, path...)
+
+	return
+}
@@ -0,0 +1,103 @@
+package world
+
+import (
+	"errors"
+	"util"
+)
+
+// Add a placed structure to the end of construction queue
+func (chunk *Chunk) Enqueue(pos util.Point) {
+	chunk.Queue = append(chunk.Queue, pos)
+}
+
+// Remove structure from construction queue, returns false if not queued
+func (chunk *Chunk) Dequeue(pos util.Point) bool {
+	for i, p := range chunk.Queue {
+		if p == pos {
+			chunk.Queue = append(chunk.Queue[:i], chunk.Queue[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check if structure at the position is in construction queue
+func (chunk Chunk) InQueue(pos util.Point) bool {
+	for _, p := range chunk.Queue {
+		if p == pos {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Move queued structure to the index of construction queue
+func (chunk *Chunk) MoveInQueue(pos util.Point, index int) error {
+	if index < 0 || index >= len(chunk.Queue) {
+		return errors.New("Queue index out of range")
+	}
+
+	if !chunk.Dequeue(pos) {
+		return errors.New("Structure not in construction queue")
+	}
+
+	chunk.Queue = append(chunk.Queue[:index], append([]util.Point{pos}, chunk.Queue[index:]...)...)
+	return nil
+}
+
+// Start queued structures in queue order while build slots are available,
+// queue entries of structures no longer queued or building are dropped.
+// Returns structures started
+func (chunk *Chunk) StartQueued(slots int, current int64) (started []Structure) {
+	var queue []util.Point
+	var building int
+
+	// Structures building occupy slots wherever they are in the queue, as
+	// reordering may move queued ones in front of them
+	for _, pos := range chunk.Queue {
+		index := chunk.structureAt(pos)
+		if index < 0 {
+			continue
+		}
+
+		switch chunk.Structures[index].Status {
+		case Building:
+			building++
+		case Queued:
+		default:
+			continue
+		}
+
+		queue = append(queue, pos)
+	}
+
+	for _, pos := range queue {
+		str := &chunk.Structures[chunk.structureAt(pos)]
+		if str.Status != Queued || building >= slots {
+			continue
+		}
+
+		str.Status = Building
+		str.BuildTime = StructMap[str.ID].BuildTime
+		str.UpdateTime = current
+		building++
+
+		started = append(started, *str)
+	}
+
+	chunk.Queue = queue
+	return
+}
+
+// Index of structure placed at the position, -1 if not found
+func (chunk Chunk) structureAt(pos util.Point) int {
+	for index, str := range chunk.Structures {
+		if str.Pos == pos {
+			return index
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,63 @@
+package world
+
+import (
+	"testing"
+	"util"
+)
+
+func TestStartQueuedKeepsSlotOfReorderedBuilding(t *testing.T) {
+	chunk := Chunk{
+		Structures: []Structure{
+			{Pos: util.Point{X: 0, Y: 0}, Status: Building},
+			{Pos: util.Point{X: 2, Y: 0}, Status: Queued},
+			{Pos: util.Point{X: 4, Y: 0}, Status: Queued},
+		},
+	}
+	for _, str := range chunk.Structures {
+		chunk.Enqueue(str.Pos)
+	}
+
+	if err := chunk.MoveInQueue(util.Point{X: 4, Y: 0}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if started := chunk.StartQueued(1, 100); len(started) != 0 {
+		t.Fatalf("started %v while the only slot is building", started)
+	}
+
+	for _, str := range chunk.Structures[1:] {
+		if str.Status != Queued {
+			t.Errorf("structure at %s is %v, want queued", str.Pos.String(), str.Status)
+		}
+	}
+
+	if len(chunk.Queue) != 3 {
+		t.Errorf("queue = %v, want all 3 entries kept", chunk.Queue)
+	}
+}
+
+func TestStartQueuedInQueueOrder(t *testing.T) {
+	chunk := Chunk{
+		Structures: []Structure{
+			{Pos: util.Point{X: 0, Y: 0}, Status: Queued},
+			{Pos: util.Point{X: 2, Y: 0}, Status: Queued},
+			{Pos: util.Point{X: 4, Y: 0}, Status: Running},
+		},
+		Queue: []util.Point{{X: 4, Y: 0}, {X: 2, Y: 0}, {X: 0, Y: 0}},
+	}
+
+	started := chunk.StartQueued(1, 100)
+	if len(started) != 1 || started[0].Pos != (util.Point{X: 2, Y: 0}) {
+		t.Fatalf("started %v, want only structure at (2, 0)", started)
+	}
+
+	if started[0].Status != Building || started[0].UpdateTime != 100 {
+		t.Errorf("started structure = %+v, want building since 100", started[0])
+	}
+
+	// Entry of running structure is dropped
+	want := []util.Point{{X: 2, Y: 0}, {X: 0, Y: 0}}
+	if len(chunk.Queue) != len(want) || chunk.Queue[0] != want[0] || chunk.Queue[1] != want[1] {
+		t.Errorf("queue = %v, want %v", chunk.Queue, want)
+	}
+}
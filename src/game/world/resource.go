@@ -0,0 +1,42 @@
+package world
+
+// Resource used to pay structure cost
+const Money = "Money"
+
+// Resource type definition
+type Resource struct {
+	Name    string
+	Initial int64 // Stock of new player
+	Rate    int64 // Base production per second of new player
+}
+
+// Resource definitions loaded with structures
+var ResourceMap map[string]Resource = make(map[string]Resource)
+
+// Scale resource amounts by level, a new map is always returned so that
+// definitions in StructMap are never modified
+func scaleResources(amounts map[string]int, level int) map[string]int {
+	res := make(map[string]int, len(amounts))
+	for name, amount := range amounts {
+		res[name] = amount * level
+	}
+
+	return res
+}
+
+// Check if two resource maps have the same non-zero amounts
+func sameResources(a, b map[string]int) bool {
+	for name, amount := range a {
+		if b[name] != amount {
+			return false
+		}
+	}
+
+	for name, amount := range b {
+		if a[name] != amount {
+			return false
+		}
+	}
+
+	return true
+}
@@ -9,36 +9,96 @@ type SStatus string
 
 const (
 	Running     SStatus = "Running"     // Running normally
+	Queued      SStatus = "Queued"      // Waiting in construction queue for a free build slot
 	Building    SStatus = "Building"    // building or upgrading
 	Destructing SStatus = "Destructing" // Being destruting by player
 	Destroyed   SStatus = "Destroyed"   // Destroyed after war
-	Halted      SStatus = "Halted"      // Halt because insufficient power
+	Halted      SStatus = "Halted"      // Halt because insufficient population or by player
+	Shed        SStatus = "Shed"        // Halt because insufficient power or resources, restarted on recovery
 )
 
+// Terrain rules for placing structure
+type PlacementRule struct {
+	MinFraction float64 // Minimum fraction of blocks accepting the terrain, 1 for all blocks, 0 for at least one block
+	Adjacent    int     // Terrain mask, one of blocks next to the structure must be these terrains
+}
+
 type Structure struct {
 	ID     int    // Structure type ID
 	Name   string // Name for frontend printing
 	Status SStatus
 
 	Population    int   // + for provide, - for occupy
-	Money         int   // + for produce, - for consume
 	Power         int   // + for generate, - for consume
 	PopulationCap int   // How many population can increase for player
+	Defense       int   // Troops added to defenders of the chunk
+	Troop         int   // Troops trained on each population update
 	BuildTime     int64 // How many time before building finish
 
+	Produce map[string]int // Resources produced per second
+	Consume map[string]int // Resources consumed per second
+
 	Cost int // Money required for build
 	// Upgrade cost: 1->2 = 1 * Cost
 	//               2->3 = 2 * Cost
 	//               3->4 = 4 * Cost
 	//               4->5 = 8 * Cost
 
+	Priority int // Consumers with lower priority are halted first on power shortage
+
 	Level    int // Building's current level
 	MaxLevel int
 
-	Chunk util.Point
-	Pos   util.Point
-	Size  util.Size
+	Chunk   util.Point
+	Pos     util.Point
+	Size    util.Size
+	Rotated bool // Rotated by 90 degrees, W & H of Size are swapped
+
+	Terrain    int // vaild construct terrain
+	Placement  PlacementRule
+	Bonuses    []Bonus // Bonuses from terrain & structures around
+	UpdateTime int64   // Unix time
+}
+
+// Money required to upgrade the structure to next level
+func (str Structure) UpgradeCost() int {
+	return str.Cost << uint(str.Level-1)
+}
+
+// Money required to repair destroyed structure, half of money spent on it
+func (str Structure) RepairCost() int {
+	return str.UpgradeCost() / 2
+}
+
+// Set structure level and scale properties from structure definition
+func (str *Structure) SetLevel(level int) {
+	def := StructMap[str.ID]
+
+	str.Level = level
+	str.Power = def.Power * level
+	str.Produce = scaleResources(def.Produce, level)
+	str.Consume = scaleResources(def.Consume, level)
+	str.Population = def.Population * level
+	str.PopulationCap = def.PopulationCap * level
+	str.Defense = def.Defense * level
+	str.Troop = def.Troop * level
+}
+
+// Net production per second of each resource
+func (str Structure) Rates() map[string]int64 {
+	rates := make(map[string]int64)
+	for name, amount := range str.Produce {
+		rates[name] += int64(amount)
+	}
+	for name, amount := range str.Consume {
+		rates[name] -= int64(amount)
+	}
+
+	return rates
+}
 
-	Terrain    int   // vaild construct terrain
-	UpdateTime int64 // Unix time
+// Check if the structure provides the same output as other one
+func (str Structure) SameOutput(other Structure) bool {
+	return str.Power == other.Power && str.Population == other.Population &&
+		sameResources(str.Produce, other.Produce) && sameResources(str.Consume, other.Consume)
 }
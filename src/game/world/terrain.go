@@ -21,6 +21,9 @@ const (
 	Volcano
 )
 
+// Mask of all valid terrain bits
+const AllTerrain TerrainType = Volcano<<1 - 1
+
 // Check if a terrain accepts struct's buildable terrain
 func (terrain TerrainType) Accepts(terrains int) bool {
 	if int(terrain)&terrains != 0 {
@@ -29,3 +32,29 @@ func (terrain TerrainType) Accepts(terrains int) bool {
 
 	return false
 }
+
+// Seconds for troops to pass through a chunk of the terrain
+var moveTime = map[TerrainType]int64{
+	Desert:  8,
+	Grass:   5,
+	Forest:  8,
+	River:   8,
+	Snow:    10,
+	Coast:   6,
+	Bank:    6,
+	Volcano: 12,
+}
+
+// Check if troops can pass through a chunk of the terrain
+func (terrain TerrainType) Passable() bool {
+	return terrain != Sea && terrain != Lava
+}
+
+// Seconds for troops to pass through a chunk of the terrain
+func (terrain TerrainType) MoveTime() int64 {
+	if t, ok := moveTime[terrain]; ok {
+		return t
+	}
+
+	return moveTime[Grass]
+}
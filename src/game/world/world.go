@@ -3,9 +3,9 @@ package world
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
-	"log"
-	"time"
+	"strings"
 	"util"
 )
 
@@ -15,7 +15,8 @@ var WorldSize util.Size = util.Size{50, 50}
 // Chunk size in blocks
 var ChunkSize util.Size = util.Size{16, 16}
 
-var StructMap map[int]Structure
+// Structure definitions, use `LoadStructures` to load
+var StructMap map[int]Structure = make(map[int]Structure)
 
 type Block struct {
 	Pos     util.Point
@@ -29,9 +30,11 @@ type Chunk struct {
 	Size           util.Size
 	Blocks         [][]Block
 	Structures     []Structure
-	Population     int64 // Population on this chunk
-	PopulationRate int64 // Population Rate of this chunk
-	UpdateTime     int64 // Unix time
+	Population     int64        // Population on this chunk
+	PopulationRate int64        // Population Rate of this chunk
+	Troops         int64        // Troops stationed on this chunk
+	Queue          []util.Point // Positions of structures in construction queue, in build order
+	UpdateTime     int64        // Unix time
 }
 
 // Get db key of chunk
@@ -39,15 +42,7 @@ func (chunk Chunk) Key() string {
 	return chunk.Pos.String()
 }
 
-func init() {
-	StructMap = make(map[int]Structure)
-	err := loadStructures("src/game/world/structures.json")
-	if err != nil {
-		log.Fatalln("[ERROR] Unable to load structure data")
-	}
-}
-
-func NewChunk(pos util.Point) *Chunk {
+func NewChunk(pos util.Point, current int64) *Chunk {
 	blocks := make([][]Block, ChunkSize.W)
 
 	for x := range blocks {
@@ -58,10 +53,37 @@ func NewChunk(pos util.Point) *Chunk {
 		}
 	}
 
-	return &Chunk{"", pos, ChunkSize, blocks, []Structure{}, 0, 0, time.Now().Unix()}
+	return &Chunk{"", pos, ChunkSize, blocks, []Structure{}, 0, 0, 0, []util.Point{}, current}
+}
+
+// Structure size in structure file, either a number for square structure or
+// an object with W & H
+type sizeProto struct {
+	W, H int
+}
+
+func (size *sizeProto) UnmarshalJSON(b []byte) error {
+	var n int
+	if err := json.Unmarshal(b, &n); err == nil {
+		size.W, size.H = n, n
+		return nil
+	}
+
+	var wh struct {
+		W, H int
+	}
+
+	if err := json.Unmarshal(b, &wh); err != nil {
+		return err
+	}
+
+	size.W, size.H = wh.W, wh.H
+	return nil
 }
 
-func loadStructures(filename string) (err error) {
+// Load structure definitions into StructMap, structure data is checked before
+// replacing the current definitions
+func LoadStructures(filename string) (err error) {
 	type strProto struct {
 		ID            int
 		Name          string
@@ -69,77 +91,362 @@ func loadStructures(filename string) (err error) {
 		Cost          int
 		Power         int
 		Population    int
-		Money         int
+		Produce       map[string]int
+		Consume       map[string]int
 		PopulationCap int
-		Size          uint
+		Defense       int
+		Troop         int
+		Size          sizeProto
 		MaxLevel      int
 		BuildTime     int64
+		Priority      int
+		Placement     struct {
+			MinFraction float64
+			Adjacent    []int
+		}
+		Bonuses []struct {
+			Terrain    []int
+			Structure  int
+			Radius     int
+			Produce    map[string]int
+			Power      int
+			Population int
+		}
 	}
 
 	protoList := struct {
+		Resources  []Resource
 		Structures []strProto
 	}{}
 
-	structjson, _ := ioutil.ReadFile(filename)
+	structjson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
 
 	if err = json.Unmarshal(structjson, &protoList); err != nil {
 		return
 	}
 
+	structMap := make(map[int]Structure)
+	resourceMap := make(map[string]Resource)
+
+	var msglist []string
+
+	for _, r := range protoList.Resources {
+		prefix := fmt.Sprintf("Resource %s: ", r.Name)
+
+		if r.Name == "" {
+			msglist = append(msglist, "Resource: empty name")
+		}
+
+		if _, ok := resourceMap[r.Name]; ok {
+			msglist = append(msglist, prefix+"duplicated name")
+		}
+
+		if r.Initial < 0 {
+			msglist = append(msglist, prefix+"initial stock must not be negative")
+		}
+
+		resourceMap[r.Name] = r
+	}
+
+	if _, ok := resourceMap[Money]; !ok {
+		msglist = append(msglist, "Resource "+Money+": required for structure cost")
+	}
+
+	// Check resource amounts of structure or bonus
+	checkResources := func(prefix string, amounts map[string]int) {
+		for name, amount := range amounts {
+			if _, ok := resourceMap[name]; !ok {
+				msglist = append(msglist, prefix+fmt.Sprintf("unknown resource %s", name))
+			}
+
+			if amount <= 0 {
+				msglist = append(msglist, prefix+fmt.Sprintf("amount of %s must be positive", name))
+			}
+		}
+	}
+
 	for _, s := range protoList.Structures {
 		var structure Structure
 
+		prefix := fmt.Sprintf("Structure %d (%s): ", s.ID, s.Name)
+
+		if _, ok := structMap[s.ID]; ok {
+			msglist = append(msglist, prefix+"duplicated ID")
+		}
+
+		if s.Size.W <= 0 || s.Size.H <= 0 {
+			msglist = append(msglist, prefix+"size must be positive")
+		}
+
+		if s.MaxLevel <= 0 {
+			msglist = append(msglist, prefix+"max level must be positive")
+		}
+
+		if len(s.Terrain) == 0 {
+			msglist = append(msglist, prefix+"no buildable terrain")
+		}
+
 		structure.ID = s.ID
 		structure.Name = s.Name
 		structure.Cost = s.Cost
 		structure.Power = s.Power
 		structure.Population = s.Population
-		structure.Money = s.Money
+		structure.Produce = s.Produce
+		structure.Consume = s.Consume
 		structure.PopulationCap = s.PopulationCap
+		structure.Defense = s.Defense
+		structure.Troop = s.Troop
 		structure.Level = 1
+
+		checkResources(prefix+"produce: ", s.Produce)
+		checkResources(prefix+"consume: ", s.Consume)
 		structure.MaxLevel = s.MaxLevel
 		structure.BuildTime = s.BuildTime
+		structure.Priority = s.Priority
 
 		for _, t := range s.Terrain {
+			if t&^int(AllTerrain) != 0 || t == 0 {
+				msglist = append(msglist, prefix+fmt.Sprintf("unknown terrain %d", t))
+			}
+
 			structure.Terrain |= t
 		}
 
-		// TODO: Support two-dimention size
-		structure.Size = util.Size{W: s.Size, H: s.Size}
+		if s.Placement.MinFraction < 0 || s.Placement.MinFraction > 1 {
+			msglist = append(msglist, prefix+"placement fraction must be between 0 and 1")
+		}
+
+		structure.Placement.MinFraction = s.Placement.MinFraction
+
+		for _, t := range s.Placement.Adjacent {
+			if t&^int(AllTerrain) != 0 || t == 0 {
+				msglist = append(msglist, prefix+fmt.Sprintf("unknown adjacent terrain %d", t))
+			}
+
+			structure.Placement.Adjacent |= t
+		}
+
+		for _, b := range s.Bonuses {
+			bonus := Bonus{Structure: b.Structure, Radius: b.Radius, Produce: b.Produce, Power: b.Power, Population: b.Population}
+
+			for _, t := range b.Terrain {
+				if t&^int(AllTerrain) != 0 || t == 0 {
+					msglist = append(msglist, prefix+fmt.Sprintf("unknown bonus terrain %d", t))
+				}
+
+				bonus.Terrain |= t
+			}
+
+			if bonus.Terrain == 0 && bonus.Structure == 0 {
+				msglist = append(msglist, prefix+"bonus requires terrain or structure")
+			}
+
+			checkResources(prefix+"bonus: ", bonus.Produce)
+
+			if bonus.Radius <= 0 {
+				msglist = append(msglist, prefix+"bonus radius must be positive")
+			}
+
+			structure.Bonuses = append(structure.Bonuses, bonus)
+		}
+
+		structure.Size = util.Size{W: uint(s.Size.W), H: uint(s.Size.H)}
+
+		structMap[structure.ID] = structure
+	}
+
+	// Check structures required by bonus exist
+	for _, structure := range structMap {
+		for _, bonus := range structure.Bonuses {
+			if _, ok := structMap[bonus.Structure]; bonus.Structure != 0 && !ok {
+				msglist = append(msglist, fmt.Sprintf("Structure %d (%s): unknown bonus structure %d", structure.ID, structure.Name, bonus.Structure))
+			}
+		}
+	}
+
+	if len(msglist) > 0 {
+		return errors.New(strings.Join(msglist, "\n"))
+	}
+
+	ResourceMap = resourceMap
+	StructMap = structMap
+	return
+}
 
-		StructMap[structure.ID] = structure
+// Chunks used by structures spanning chunk borders, keyed by chunk position
+type Area map[util.Point]*Chunk
+
+// Chunks in the area, used for writing them back
+func (area Area) Chunks() (chunks []Chunk) {
+	for _, chunk := range area {
+		chunks = append(chunks, *chunk)
 	}
 
 	return
 }
 
-func (chunk Chunk) Accepts(str Structure) (ok bool, err error) {
-	var terr_ok bool
+// Locate a block given by position relative to the chunk, the block may be in
+// another chunk. Returns position of the chunk and the block in that chunk
+func locate(chunk util.Point, point util.Point) (util.Point, util.Point) {
+	w, h := int(ChunkSize.W), int(ChunkSize.H)
+
+	// Round down for negative position
+	dx, dy := point.X/w, point.Y/h
+	if point.X < 0 && point.X%w != 0 {
+		dx--
+	}
+	if point.Y < 0 && point.Y%h != 0 {
+		dy--
+	}
+
+	return util.Point{X: chunk.X + dx, Y: chunk.Y + dy}, util.Point{X: point.X - dx*w, Y: point.Y - dy*h}
+}
+
+// Blocks covered by the structure, grouped by chunk position. Structure may
+// extend to chunks on the right & bottom of the chunk it belongs to
+func Footprint(str Structure) map[util.Point][]util.Point {
+	res := make(map[util.Point][]util.Point)
 
 	for _, point := range util.InSizeRange(str.Pos, str.Size) {
-		if uint(point.X) >= ChunkSize.W || uint(point.Y) >= ChunkSize.H {
-			err = errors.New("Structure out of chunk")
+		chunk, block := locate(str.Chunk, point)
+		res[chunk] = append(res[chunk], block)
+	}
+
+	return res
+}
+
+// Check if the structure covers any block of the chunk
+func Covers(str Structure, chunk util.Point) bool {
+	_, ok := Footprint(str)[chunk]
+	return ok
+}
+
+// Chunks where structures covering the chunk may be placed, including the
+// chunk itself. Structures only extend to the right & bottom, so these are
+// chunks on the left & top within the size of the largest structure
+func AnchorChunks(chunk util.Point) (res []util.Point) {
+	dx, dy := structReach()
+
+	for x := chunk.X - dx; x <= chunk.X; x++ {
+		for y := chunk.Y - dy; y <= chunk.Y; y++ {
+			res = append(res, util.Point{X: x, Y: y})
+		}
+	}
+
+	return
+}
+
+// Chunks which structures placed on the chunk may cover, including the chunk
+// itself. These are chunks on the right & bottom, opposite to AnchorChunks
+func ReachChunks(chunk util.Point) (res []util.Point) {
+	dx, dy := structReach()
+
+	for x := chunk.X; x <= chunk.X+dx; x++ {
+		for y := chunk.Y; y <= chunk.Y+dy; y++ {
+			res = append(res, util.Point{X: x, Y: y})
+		}
+	}
+
+	return
+}
+
+// Number of chunks the largest structure may extend beyond its own chunk
+func structReach() (dx, dy int) {
+	var size int
+	for _, str := range StructMap {
+		if int(str.Size.W) > size {
+			size = int(str.Size.W)
+		}
+		if int(str.Size.H) > size {
+			size = int(str.Size.H)
+		}
+	}
+
+	// Farthest chunk reached by a structure placed at the last block
+	dx = (int(ChunkSize.W) + size - 2) / int(ChunkSize.W)
+	dy = (int(ChunkSize.H) + size - 2) / int(ChunkSize.H)
+	return
+}
+
+// Get block by position relative to the chunk, returns nil if the block is not in the area
+func (area Area) block(chunk util.Point, point util.Point) *Block {
+	cpos, bpos := locate(chunk, point)
+
+	if c, ok := area[cpos]; ok {
+		return &c.Blocks[bpos.X][bpos.Y]
+	}
+
+	return nil
+}
+
+func (area Area) Accepts(str Structure) (ok bool, err error) {
+	var terr_num int
+
+	if str.Pos.X < 0 || str.Pos.Y < 0 || uint(str.Pos.X) >= ChunkSize.W || uint(str.Pos.Y) >= ChunkSize.H {
+		err = errors.New("Structure out of chunk")
+		return
+	}
+
+	points := util.InSizeRange(str.Pos, str.Size)
+
+	for _, point := range points {
+		block := area.block(str.Chunk, point)
+		if block == nil {
+			err = errors.New("Structure out of available chunks")
 			return
 		}
 		// Check available space
-		if !chunk.Blocks[point.X][point.Y].Empty {
+		if !block.Empty {
 			err = errors.New("Map occupied")
 			return
 		}
 
 		// Check terrain
-		if !terr_ok && chunk.Blocks[point.X][point.Y].Terrain.Accepts(str.Terrain) {
-			terr_ok = true
+		if block.Terrain.Accepts(str.Terrain) {
+			terr_num++
 		}
 	}
 
-	if !terr_ok {
+	if terr_num == 0 {
 		err = errors.New("Terrain check failed")
 		return
-	} else {
-		ok = true
+	}
+
+	if float64(terr_num) < str.Placement.MinFraction*float64(len(points)) {
+		err = fmt.Errorf("Terrain check failed, %d of %d blocks are buildable, %.0f%% required", terr_num, len(points), str.Placement.MinFraction*100)
+		return
+	}
+
+	if str.Placement.Adjacent != 0 && !area.nextTo(str, str.Placement.Adjacent) {
+		err = errors.New("Terrain check failed, structure is not next to required terrain")
 		return
 	}
+
+	ok = true
+	return
+}
+
+// Check if any block around the structure accepts the terrain mask
+func (area Area) nextTo(str Structure, terrains int) bool {
+	pos, size := str.Pos, str.Size
+	around := util.InSizeRange(util.Point{X: pos.X - 1, Y: pos.Y - 1}, util.Size{W: size.W + 2, H: size.H + 2})
+
+	for _, point := range around {
+		// Skip blocks under the structure
+		if point.X >= pos.X && point.X < pos.X+int(size.W) && point.Y >= pos.Y && point.Y < pos.Y+int(size.H) {
+			continue
+		}
+
+		// Blocks out of the area are skipped
+		if block := area.block(str.Chunk, point); block != nil && block.Terrain.Accepts(terrains) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (chunk Chunk) PopulationNeed() (needed int64) {
@@ -152,17 +459,47 @@ func (chunk Chunk) PopulationNeed() (needed int64) {
 	return
 }
 
+// Troops provided by military structures when the chunk is attacked
+func (chunk Chunk) Defense() (defense int64) {
+	for _, str := range chunk.Structures {
+		if str.Status == Running {
+			defense += int64(str.Defense)
+		}
+	}
+
+	return
+}
+
+// Troops trained by military structures on each population update
+func (chunk Chunk) TroopRate() (rate int64) {
+	for _, str := range chunk.Structures {
+		if str.Status == Running {
+			rate += int64(str.Troop)
+		}
+	}
+
+	return
+}
+
 // Fill remained part of struct from client
 func CompleteStructure(str *Structure) {
 	chunk := str.Chunk
 	pos := str.Pos
+	rotated := str.Rotated
 
 	// Load default values
 	*str = StructMap[str.ID]
+	str.Produce = scaleResources(str.Produce, 1)
+	str.Consume = scaleResources(str.Consume, 1)
 
-	// Restore position
+	// Restore position & direction
 	str.Chunk = chunk
 	str.Pos = pos
+	str.Rotated = rotated
+
+	if rotated {
+		str.Size.W, str.Size.H = str.Size.H, str.Size.W
+	}
 }
 
 func GetStructure(chunk Chunk, str Structure) (str_index int, err error) {
@@ -183,20 +520,25 @@ func GetStructure(chunk Chunk, str Structure) (str_index int, err error) {
 	return
 }
 
-func BuildStructure(chunk *Chunk, str Structure) (err error) {
+func BuildStructure(area Area, str Structure, current int64) (err error) {
+	chunk, ok := area[str.Chunk]
+	if !ok {
+		return errors.New("Chunk not available")
+	}
+
 	// Check available space & terrain
-	if ok, err := chunk.Accepts(str); !ok {
+	if ok, err := area.Accepts(str); !ok {
 		return err
 	}
 
 	// Check finished, build the structure
 
 	// Set map occupied
-	for _, block := range util.InSizeRange(str.Pos, str.Size) {
-		chunk.Blocks[block.X][block.Y].Empty = false
+	for _, point := range util.InSizeRange(str.Pos, str.Size) {
+		area.block(str.Chunk, point).Empty = false
 	}
 
-	str.UpdateTime = time.Now().Unix()
+	str.UpdateTime = current
 
 	// Add structure
 	chunk.Structures = append(chunk.Structures, str)
@@ -204,16 +546,30 @@ func BuildStructure(chunk *Chunk, str Structure) (err error) {
 	return
 }
 
-func DestructStructure(chunk *Chunk, str Structure) (err error) {
+func DestructStructure(area Area, str Structure) (err error) {
+	chunk, ok := area[str.Chunk]
+	if !ok {
+		return errors.New("Chunk not available")
+	}
+
 	index, err := GetStructure(*chunk, str)
 
 	if err != nil {
 		return
 	}
 
+	// Use size of built structure since the given one may not be rotated
+	size := chunk.Structures[index].Size
+
+	for pos := range Footprint(chunk.Structures[index]) {
+		if _, ok := area[pos]; !ok {
+			return errors.New("Chunk not available")
+		}
+	}
+
 	// Set map free
-	for _, block := range util.InSizeRange(str.Pos, str.Size) {
-		chunk.Blocks[block.X][block.Y].Empty = true
+	for _, point := range util.InSizeRange(str.Pos, size) {
+		area.block(str.Chunk, point).Empty = true
 	}
 
 	// delete structure
@@ -221,3 +577,75 @@ func DestructStructure(chunk *Chunk, str Structure) (err error) {
 
 	return
 }
+
+func UpgradeStructure(chunk *Chunk, str Structure, current int64) (err error) {
+	index, err := GetStructure(*chunk, str)
+
+	if err != nil {
+		return
+	}
+
+	target := &chunk.Structures[index]
+
+	if target.Status != Running && target.Status != Halted && target.Status != Shed {
+		return errors.New("Structure is not available for upgrade")
+	}
+
+	if target.Level >= target.MaxLevel {
+		return errors.New("Structure already reaches max level")
+	}
+
+	// Structure stops functioning until upgrade finished
+	target.SetLevel(target.Level + 1)
+	target.Status = Building
+	target.BuildTime = StructMap[target.ID].BuildTime
+	target.UpdateTime = current
+
+	return
+}
+
+// Set structure destroyed, the structure still occupies the map
+func DestroyStructure(chunk *Chunk, str Structure, current int64) (err error) {
+	index, err := GetStructure(*chunk, str)
+
+	if err != nil {
+		return
+	}
+
+	target := &chunk.Structures[index]
+
+	if target.Status == Destroyed {
+		return errors.New("Structure is not available for destroy")
+	}
+
+	// Destruction stops, restore properties cleared by destruction
+	if target.Status == Destructing {
+		target.SetLevel(target.Level)
+	}
+
+	target.Status = Destroyed
+	target.BuildTime = 0
+	target.UpdateTime = current
+
+	return
+}
+
+func RepairStructure(chunk *Chunk, str Structure, current int64) (err error) {
+	index, err := GetStructure(*chunk, str)
+
+	if err != nil {
+		return
+	}
+
+	target := &chunk.Structures[index]
+
+	if target.Status != Destroyed {
+		return errors.New("Structure is not destroyed")
+	}
+
+	target.Status = Building
+	target.BuildTime = StructMap[target.ID].BuildTime
+	target.UpdateTime = current
+
+	return
+}
@@ -0,0 +1,226 @@
+package world
+
+import (
+	"testing"
+	"util"
+)
+
+// Replace structure catalog for the test
+func setStructMap(t *testing.T, structs map[int]Structure) {
+	saved := StructMap
+	t.Cleanup(func() { StructMap = saved })
+
+	StructMap = make(map[int]Structure)
+	for id, str := range structs {
+		str.ID = id
+		StructMap[id] = str
+	}
+}
+
+func TestCompleteStructureRotation(t *testing.T) {
+	setStructMap(t, map[int]Structure{
+		1: {Size: util.Size{W: 3, H: 1}, Level: 1},
+		2: {Size: util.Size{W: 2, H: 2}, Level: 1},
+	})
+
+	cases := []struct {
+		id      int
+		rotated bool
+		want    util.Size
+	}{
+		{1, false, util.Size{W: 3, H: 1}},
+		{1, true, util.Size{W: 1, H: 3}},
+		{2, false, util.Size{W: 2, H: 2}},
+		{2, true, util.Size{W: 2, H: 2}},
+	}
+
+	for _, c := range cases {
+		str := Structure{ID: c.id, Chunk: util.Point{X: -1, Y: 2}, Pos: util.Point{X: 4, Y: 5}, Rotated: c.rotated, Size: util.Size{W: 9, H: 9}}
+		CompleteStructure(&str)
+
+		if str.Size != c.want {
+			t.Errorf("structure %d rotated %v: size %v, want %v", c.id, c.rotated, str.Size, c.want)
+		}
+		if str.Rotated != c.rotated || str.Chunk != (util.Point{X: -1, Y: 2}) || str.Pos != (util.Point{X: 4, Y: 5}) {
+			t.Errorf("structure %d rotated %v: placement not kept, got %+v", c.id, c.rotated, str)
+		}
+	}
+
+	// Definition is never rotated
+	if StructMap[1].Size != (util.Size{W: 3, H: 1}) {
+		t.Errorf("definition size changed to %v", StructMap[1].Size)
+	}
+}
+
+func TestAreaAcceptsPlacementRules(t *testing.T) {
+	// Grass on the left half of the chunk, sea on the right half
+	pos := util.Point{X: 0, Y: 0}
+	chunk := NewChunk(pos, 0)
+	for x := range chunk.Blocks {
+		for y := range chunk.Blocks[x] {
+			chunk.Blocks[x][y].Terrain = Grass
+			if x >= int(ChunkSize.W)/2 {
+				chunk.Blocks[x][y].Terrain = Sea
+			}
+		}
+	}
+	area := Area{pos: chunk}
+
+	half := int(ChunkSize.W) / 2
+
+	cases := []struct {
+		name string
+		x    int
+		rule PlacementRule
+		want bool
+	}{
+		{"all blocks on grass", 0, PlacementRule{MinFraction: 1}, true},
+		{"half on sea, all required", half - 1, PlacementRule{MinFraction: 1}, false},
+		{"half on sea, half required", half - 1, PlacementRule{MinFraction: 0.5}, true},
+		{"half on sea, any block", half - 1, PlacementRule{}, true},
+		{"all blocks on sea", half, PlacementRule{}, false},
+		{"not next to sea", half - 3, PlacementRule{Adjacent: int(Sea)}, false},
+		{"next to sea", half - 2, PlacementRule{Adjacent: int(Sea)}, true},
+		{"next to sea on grass only", half - 2, PlacementRule{MinFraction: 1, Adjacent: int(Sea)}, true},
+	}
+
+	for _, c := range cases {
+		str := Structure{Terrain: int(Grass), Placement: c.rule, Chunk: pos, Pos: util.Point{X: c.x, Y: 1}, Size: util.Size{W: 2, H: 1}}
+
+		if ok, err := area.Accepts(str); ok != c.want {
+			t.Errorf("%s: accepts %v (%v), want %v", c.name, ok, err, c.want)
+		}
+	}
+}
+
+func TestLocate(t *testing.T) {
+	w, h := int(ChunkSize.W), int(ChunkSize.H)
+
+	cases := []struct {
+		chunk, point       util.Point
+		wantChunk, wantPos util.Point
+	}{
+		{util.Point{X: 0, Y: 0}, util.Point{X: 0, Y: 0}, util.Point{X: 0, Y: 0}, util.Point{X: 0, Y: 0}},
+		{util.Point{X: 0, Y: 0}, util.Point{X: w - 1, Y: h - 1}, util.Point{X: 0, Y: 0}, util.Point{X: w - 1, Y: h - 1}},
+		{util.Point{X: 0, Y: 0}, util.Point{X: w, Y: 0}, util.Point{X: 1, Y: 0}, util.Point{X: 0, Y: 0}},
+		{util.Point{X: 0, Y: 0}, util.Point{X: -1, Y: 0}, util.Point{X: -1, Y: 0}, util.Point{X: w - 1, Y: 0}},
+		{util.Point{X: 0, Y: 0}, util.Point{X: -w, Y: -h - 1}, util.Point{X: -1, Y: -2}, util.Point{X: 0, Y: h - 1}},
+		{util.Point{X: 2, Y: -3}, util.Point{X: 2*w + 1, Y: -1}, util.Point{X: 4, Y: -4}, util.Point{X: 1, Y: h - 1}},
+	}
+
+	for _, c := range cases {
+		chunk, pos := locate(c.chunk, c.point)
+		if chunk != c.wantChunk || pos != c.wantPos {
+			t.Errorf("locate(%s, %s) = %s, %s, want %s, %s", c.chunk.String(), c.point.String(), chunk.String(), pos.String(), c.wantChunk.String(), c.wantPos.String())
+		}
+	}
+}
+
+func TestFootprintAcrossBorders(t *testing.T) {
+	last := int(ChunkSize.W) - 1
+	anchor := util.Point{X: -1, Y: 0}
+
+	cases := []struct {
+		name string
+		str  Structure
+		want map[util.Point][]util.Point
+	}{
+		{
+			"inside chunk",
+			Structure{Chunk: anchor, Pos: util.Point{X: 0, Y: 0}, Size: util.Size{W: 2, H: 2}},
+			map[util.Point][]util.Point{anchor: {{X: 0, Y: 0}, {X: 0, Y: 1}, {X: 1, Y: 0}, {X: 1, Y: 1}}},
+		},
+		{
+			"right border",
+			Structure{Chunk: anchor, Pos: util.Point{X: last, Y: 0}, Size: util.Size{W: 2, H: 1}},
+			map[util.Point][]util.Point{anchor: {{X: last, Y: 0}}, {X: 0, Y: 0}: {{X: 0, Y: 0}}},
+		},
+		{
+			"corner",
+			Structure{Chunk: anchor, Pos: util.Point{X: last, Y: last}, Size: util.Size{W: 2, H: 2}},
+			map[util.Point][]util.Point{
+				anchor:        {{X: last, Y: last}},
+				{X: -1, Y: 1}: {{X: last, Y: 0}},
+				{X: 0, Y: 0}:  {{X: 0, Y: last}},
+				{X: 0, Y: 1}:  {{X: 0, Y: 0}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		got := Footprint(c.str)
+		if !sameFootprint(got, c.want) {
+			t.Errorf("%s: footprint %v, want %v", c.name, got, c.want)
+		}
+
+		// Structure is found from each chunk it covers
+		setStructMap(t, map[int]Structure{1: {Size: c.str.Size}})
+		for pos := range got {
+			if !containsPoint(AnchorChunks(pos), c.str.Chunk) {
+				t.Errorf("%s: anchor chunks of %s miss %s", c.name, pos.String(), c.str.Chunk.String())
+			}
+			if !containsPoint(ReachChunks(c.str.Chunk), pos) {
+				t.Errorf("%s: reach chunks of %s miss %s", c.name, c.str.Chunk.String(), pos.String())
+			}
+		}
+	}
+}
+
+func TestAnchorChunksBySize(t *testing.T) {
+	w := int(ChunkSize.W)
+	pos := util.Point{X: 3, Y: -2}
+
+	cases := []struct {
+		size int
+		want int // chunks on each axis
+	}{
+		{1, 1},
+		{2, 2},
+		{w + 1, 2},
+		{w + 2, 3},
+	}
+
+	for _, c := range cases {
+		setStructMap(t, map[int]Structure{1: {Size: util.Size{W: uint(c.size), H: 1}}})
+
+		anchors := AnchorChunks(pos)
+		if len(anchors) != c.want*c.want {
+			t.Errorf("size %d: %d anchor chunks, want %d", c.size, len(anchors), c.want*c.want)
+		}
+		for _, p := range anchors {
+			if p.X > pos.X || p.Y > pos.Y || p.X <= pos.X-c.want || p.Y <= pos.Y-c.want {
+				t.Errorf("size %d: anchor chunk %s not on the left & top of %s", c.size, p.String(), pos.String())
+			}
+		}
+	}
+}
+
+func sameFootprint(a, b map[util.Point][]util.Point) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for chunk, blocks := range a {
+		if len(blocks) != len(b[chunk]) {
+			return false
+		}
+
+		for _, block := range blocks {
+			if !containsPoint(b[chunk], block) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func containsPoint(points []util.Point, point util.Point) bool {
+	for _, p := range points {
+		if p == point {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,133 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source of current time, replaceable for simulation & tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// Clock following system time
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Clock running faster than system time for playtesting, game time starts
+// from origin when the clock is created
+type ScaledClock struct {
+	origin time.Time
+	start  time.Time
+	speed  float64
+}
+
+func NewScaledClock(origin time.Time, speed float64) ScaledClock {
+	return ScaledClock{origin, time.Now(), speed}
+}
+
+func (clock ScaledClock) Now() time.Time {
+	return clock.origin.Add(time.Duration(float64(time.Since(clock.start)) * clock.speed))
+}
+
+func (clock ScaledClock) After(d time.Duration) <-chan time.Time {
+	return time.After(time.Duration(float64(d) / clock.speed))
+}
+
+// Scaled clock continuing from game time saved in the file, so game time
+// never goes back after restart. Starts from system time if the file doesn't
+// exist or system time is later
+func LoadScaledClock(filename string, speed float64) (clock ScaledClock, err error) {
+	origin := time.Now()
+
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return NewScaledClock(origin, speed), nil
+	} else if err != nil {
+		return
+	}
+
+	nsec, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return
+	}
+
+	if saved := time.Unix(0, nsec); saved.After(origin) {
+		origin = saved
+	}
+
+	return NewScaledClock(origin, speed), nil
+}
+
+// Save current game time to the file for LoadScaledClock
+func (clock ScaledClock) Save(filename string) error {
+	return ioutil.WriteFile(filename, []byte(strconv.FormatInt(clock.Now().UnixNano(), 10)), 0644)
+}
+
+// Clock moving only when advanced, for deterministic simulation & tests
+type ManualClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []clockWaiter
+}
+
+type clockWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (clock *ManualClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+
+	return clock.now
+}
+
+// Channel receives time after the clock is advanced by the duration
+func (clock *ManualClock) After(d time.Duration) <-chan time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- clock.now
+		return ch
+	}
+
+	clock.waiters = append(clock.waiters, clockWaiter{clock.now.Add(d), ch})
+	return ch
+}
+
+// Move the clock forward and fire timers reaching their deadlines
+func (clock *ManualClock) Advance(d time.Duration) {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+
+	clock.now = clock.now.Add(d)
+
+	var waiters []clockWaiter
+	for _, w := range clock.waiters {
+		if w.deadline.After(clock.now) {
+			waiters = append(waiters, w)
+		} else {
+			w.ch <- clock.now
+		}
+	}
+	clock.waiters = waiters
+}
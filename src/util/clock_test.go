@@ -0,0 +1,56 @@
+package util
+
+import (
+	"path"
+	"testing"
+	"time"
+)
+
+func TestManualClockFiresAfterAdvance(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000000, 0))
+	ch := clock.After(10 * time.Second)
+
+	clock.Advance(9 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("fired before deadline")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case now := <-ch:
+		if now.Unix() != 1000010 {
+			t.Fatalf("fired at %v", now)
+		}
+	default:
+		t.Fatal("not fired at deadline")
+	}
+}
+
+func TestScaledClockContinuesFromSavedTime(t *testing.T) {
+	filename := path.Join(t.TempDir(), "clock")
+
+	// Game time far ahead of system time after running fast
+	ahead := NewScaledClock(time.Now().Add(time.Hour), 60)
+	if err := ahead.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	clock, err := LoadScaledClock(filename, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clock.Now().Before(time.Now().Add(59 * time.Minute)) {
+		t.Fatalf("game time %v went back after restart", clock.Now())
+	}
+
+	// Starts from system time without saved time
+	fresh, err := LoadScaledClock(path.Join(t.TempDir(), "clock"), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := fresh.Now().Sub(time.Now()); d < -time.Second || d > time.Second {
+		t.Fatalf("game time %v, want close to system time", fresh.Now())
+	}
+}